@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/dedelala/sysexits"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"go.pkg.littleman.co/library/internal/book"
+)
+
+// exportCmd writes a book out as a static website
+var exportCmd = &cobra.Command{
+	Use:   "export book",
+	Short: "Write a book out as a static website",
+	Long: `Write a book out as a static website, that can be put on any static host and read just as it is when served.
+
+The book may be a path, or an http(s):// or s3://bucket/key URL. Markup is injected into it as configured for serve.
+With --single, the book is written as a single HTML file with everything it needs inlined, instead of a directory.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output := viper.GetString("export.output")
+
+		if len(output) == 0 {
+			fmt.Println("unable to export: no output set")
+			os.Exit(sysexits.Usage)
+		}
+
+		injection, err := book.NewInjection(injectionOptions()...)
+
+		if err != nil {
+			fmt.Printf("unable to export: injection configuration invalid: %s\n", err)
+			os.Exit(sysexits.DataErr)
+		}
+
+		src, err := book.ParseSource(args[0], s3Config())
+
+		if err != nil {
+			fmt.Printf("unable to export: %s\n", err)
+			os.Exit(sysexits.Usage)
+		}
+
+		trust, err := bookTrust(book.Slug(src.Name()))
+
+		if err != nil {
+			fmt.Printf("unable to export: %s\n", err)
+			os.Exit(sysexits.Usage)
+		}
+
+		b, err := book.New(book.WithSource(src), book.WithInjection(injection), book.WithTrust(trust))
+
+		if err != nil {
+			fmt.Printf("unable to export: %s\n", err)
+			os.Exit(sysexits.DataErr)
+		}
+
+		defer b.Close()
+
+		if viper.GetBool("export.single") {
+			err = exportSingle(b, output)
+		} else {
+			err = b.Export(output)
+		}
+
+		if err != nil {
+			fmt.Printf("unable to export: %s\n", err)
+			b.Close()
+			os.Exit(sysexits.CantCreat)
+		}
+	},
+}
+
+// exportSingle writes the book out as a single HTML file at path
+func exportSingle(b *book.Book, path string) error {
+	f, err := os.Create(path)
+
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+
+	if err := b.ExportSingle(w); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringP("output", "o", "", "The directory to write the book to, or with --single, the file.")
+
+	exportCmd.Flags().Bool("single", false, "Write the book as a single HTML file, with everything it needs inlined.")
+
+	viper.BindPFlag("export.output", exportCmd.Flags().Lookup("output"))
+	viper.BindPFlag("export.single", exportCmd.Flags().Lookup("single"))
+}
@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dedelala/sysexits"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"go.pkg.littleman.co/library/internal/book"
+)
+
+const formatTable = "table"
+
+// inspection is everything there is to know about a book, as the server sees it
+type inspection struct {
+	Path      string              `json:"path"`
+	Slug      string              `json:"slug"`
+	Root      string              `json:"root"`
+	Trust     string              `json:"trust"`
+	Metadata  book.Metadata       `json:"metadata"`
+	Cover     *book.Cover         `json:"cover"`
+	Manifest  []book.ManifestItem `json:"manifest"`
+	Spine     []book.SpineItem    `json:"spine"`
+	Contents  []book.Contents     `json:"contents"`
+	Sanitised []book.Sanitised    `json:"sanitised"`
+}
+
+// inspectCmd describes what is in books
+var inspectCmd = &cobra.Command{
+	Use:   "inspect [book...]",
+	Short: "Describe the metadata, files, reading order and table of contents of books",
+	Long: `Describe the metadata, files, reading order and table of contents of books, as they are read when served.
+
+Each book may be a path, or an http(s):// or s3://bucket/key URL. Without any, the books that would be served are
+described.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		format := viper.GetString("inspect.format")
+
+		if format != formatTable && format != formatJSON {
+			fmt.Printf("unable to inspect: unknown format %s, must be %s or %s\n", format, formatTable, formatJSON)
+			os.Exit(sysexits.Usage)
+		}
+
+		locations, err := bookLocations(args)
+
+		if err != nil {
+			fmt.Printf("unable to inspect: %s\n", err)
+			os.Exit(sysexits.NoInput)
+		}
+
+		inspections := []inspection{}
+
+		for _, loc := range locations {
+			i, err := inspect(loc)
+
+			if err != nil {
+				fmt.Printf("unable to inspect %s: %s\n", loc, err)
+				os.Exit(sysexits.DataErr)
+			}
+
+			inspections = append(inspections, i)
+		}
+
+		if format == formatJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(inspections)
+
+			return
+		}
+
+		for n, i := range inspections {
+			if n > 0 {
+				fmt.Println()
+			}
+
+			printInspection(os.Stdout, i)
+		}
+	},
+}
+
+// inspect opens the book at location the way the server does, and describes it
+func inspect(location string) (inspection, error) {
+	src, err := book.ParseSource(location, s3Config())
+
+	if err != nil {
+		return inspection{}, err
+	}
+
+	trust, err := bookTrust(book.Slug(src.Name()))
+
+	if err != nil {
+		return inspection{}, err
+	}
+
+	b, err := book.New(book.WithSource(src), book.WithTrust(trust))
+
+	if err != nil {
+		return inspection{}, err
+	}
+
+	defer b.Close()
+
+	return inspection{
+		Path:      b.Path,
+		Slug:      b.Slug,
+		Root:      b.Root,
+		Trust:     trust.String(),
+		Metadata:  b.Metadata,
+		Cover:     b.Cover,
+		Manifest:  b.Manifest(),
+		Spine:     b.Spine,
+		Contents:  b.Contents,
+		Sanitised: b.Sanitised(),
+	}, nil
+}
+
+// printInspection describes a book for people to read, as a series of tables
+func printInspection(out io.Writer, i inspection) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	m := i.Metadata
+
+	creators := []string{}
+
+	for _, c := range m.Creators {
+		if len(c.Role) > 0 {
+			creators = append(creators, fmt.Sprintf("%s (%s)", c.Name, c.Role))
+		} else {
+			creators = append(creators, c.Name)
+		}
+	}
+
+	identifiers := []string{}
+
+	for _, id := range m.Identifiers {
+		if len(id.Scheme) > 0 {
+			identifiers = append(identifiers, fmt.Sprintf("%s (%s)", id.Value, id.Scheme))
+		} else {
+			identifiers = append(identifiers, id.Value)
+		}
+	}
+
+	cover := ""
+
+	if i.Cover != nil {
+		cover = fmt.Sprintf("%s (%s)", i.Cover.Path, i.Cover.MediaType)
+	}
+
+	for _, row := range [][2]string{
+		{"Book", i.Path},
+		{"Slug", i.Slug},
+		{"Content root", i.Root},
+		{"Trust", i.Trust},
+		{"Title", m.Title},
+		{"Creators", strings.Join(creators, ", ")},
+		{"Language", strings.Join(m.Language, ", ")},
+		{"Identifiers", strings.Join(identifiers, ", ")},
+		{"Modified", m.Modified},
+		{"Publisher", m.Publisher},
+		{"Subjects", strings.Join(m.Subjects, ", ")},
+		{"Rights", m.Rights},
+		{"Description", m.Description},
+		{"Cover", cover},
+	} {
+		fmt.Fprintf(w, "%s\t%s\n", row[0], row[1])
+	}
+
+	fmt.Fprintf(w, "\nMANIFEST\n")
+	fmt.Fprintf(w, "ID\tPATH\tMEDIA TYPE\tSIZE\tPROPERTIES\n")
+
+	for _, item := range i.Manifest {
+		size := fmt.Sprintf("%d", item.Size)
+
+		if item.Missing {
+			size = "missing"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", item.ID, item.Path, item.MediaType, size, strings.Join(item.Properties, " "))
+	}
+
+	fmt.Fprintf(w, "\nSPINE\n")
+	fmt.Fprintf(w, "#\tID\tPATH\tLINEAR\n")
+
+	for n, item := range i.Spine {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%t\n", n+1, item.ID, item.Path, item.Linear)
+	}
+
+	// What was removed is only listed if there was anything, as it is for the authors of the book to fix
+	if len(i.Sanitised) > 0 {
+		fmt.Fprintf(w, "\nSANITISED\n")
+		fmt.Fprintf(w, "PATH\tKIND\tNAME\tREASON\tCOUNT\n")
+
+		for _, s := range i.Sanitised {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", s.Path, s.Kind, s.Name, s.Reason, s.Count)
+		}
+	}
+
+	w.Flush()
+
+	fmt.Fprintf(out, "\nCONTENTS\n")
+	printContents(out, i.Contents, 0)
+}
+
+// printContents prints the table of contents as a tree, each entry indented under its parent
+func printContents(out io.Writer, contents []book.Contents, depth int) {
+	for _, c := range contents {
+		fmt.Fprintf(out, "%s%s", strings.Repeat("  ", depth), c.Title)
+
+		if len(c.Href) > 0 {
+			fmt.Fprintf(out, " -> %s", c.Href)
+		}
+
+		fmt.Fprintln(out)
+
+		printContents(out, c.Children, depth+1)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+
+	inspectCmd.Flags().StringP("format", "f", formatTable, "How to describe the books; table or json.")
+
+	viper.BindPFlag("inspect.format", inspectCmd.Flags().Lookup("format"))
+}
@@ -34,9 +34,15 @@ func init() {
 	cobra.OnInitialize(initConfig)
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default /etc/.library.yaml)")
-	rootCmd.PersistentFlags().StringP("book-path", "p", "/book.epub", "The path to the book that should be rendered")
+	rootCmd.PersistentFlags().StringSliceP("book-path", "p", []string{"/book.epub"}, "The path to the book that should be rendered, or its http(s):// or s3://bucket/key URL. May be repeated.")
+	rootCmd.PersistentFlags().StringP("library-path", "l", "", "A directory, every book in which should be rendered")
+	rootCmd.PersistentFlags().String("trust", "untrusted", "How far the content of books is trusted; untrusted removes anything that could run a script, strict also removes anything embedded from elsewhere and trusted leaves books as they are.")
+	rootCmd.PersistentFlags().StringToString("trust-book", map[string]string{}, "How far the content of a particular book is trusted, as slug=level. May be repeated.")
 
 	viper.BindPFlag("book.path", rootCmd.PersistentFlags().Lookup("book-path"))
+	viper.BindPFlag("library.path", rootCmd.PersistentFlags().Lookup("library-path"))
+	viper.BindPFlag("book.trust.default", rootCmd.PersistentFlags().Lookup("trust"))
+	viper.BindPFlag("book.trust.books", rootCmd.PersistentFlags().Lookup("trust-book"))
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -51,7 +57,7 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
 
 	viper.SetEnvPrefix("LIBRARY_")
@@ -4,11 +4,14 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"time"
 
 	"github.com/dedelala/sysexits"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"go.pkg.littleman.co/library/internal/book"
 	"go.pkg.littleman.co/library/internal/server"
 	"go.pkg.littleman.co/library/internal/server/middleware"
 )
@@ -20,8 +23,84 @@ var serveCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		// Default Options
 		options := []server.Option{
-			server.WithBook(viper.GetString("book.path")),
 			server.WithLogging(),
+			server.WithReload(viper.GetDuration("library.reload_interval")),
+			server.WithFetch(viper.GetDuration("library.fetch_interval")),
+			server.WithS3(s3Config()),
+		}
+
+		// Serve a directory of books if one is set, and the explicitly listed books (or the default book) otherwise.
+		if viper.IsSet("library.path") {
+			options = append(options, server.WithLibrary(viper.GetString("library.path")))
+		}
+
+		if !viper.IsSet("library.path") || viper.IsSet("book.path") {
+			options = append(options, server.WithBook(viper.GetStringSlice("book.path")...))
+		}
+
+		// Add markup to each page. Nothing is added unless configured.
+		injection, err := book.NewInjection(injectionOptions()...)
+
+		if err != nil {
+			fmt.Printf("unable to start server: injection configuration invalid: %s", err.Error())
+			os.Exit(sysexits.DataErr)
+		}
+
+		options = append(options, server.WithInjection(injection))
+
+		// Books are only served with scripts and the like in them if they are trusted to be
+		trust, err := trustOptions()
+
+		if err != nil {
+			fmt.Printf("unable to start server: %s", err.Error())
+			os.Exit(sysexits.Usage)
+		}
+
+		options = append(options, trust...)
+
+		// Drafts that should not leave the library can be read, but not downloaded
+		if !viper.GetBool("book.download.enabled") {
+			options = append(options, server.WithoutDownloads())
+		}
+
+		if slugs := viper.GetStringSlice("book.download.disabled"); len(slugs) > 0 {
+			options = append(options, server.WithoutDownloads(slugs...))
+		}
+
+		// Show books with their covers
+		options = append(options, server.WithThumbnails(viper.GetIntSlice("book.thumbnails.sizes"), viper.GetString("book.thumbnails.path")))
+
+		// Offer smaller variants of images to small screens. As a flag cannot be set to an empty list, a width of 0 is
+		// how none are offered.
+		widths := []int{}
+
+		for _, w := range viper.GetIntSlice("book.images.widths") {
+			if w != 0 {
+				widths = append(widths, w)
+			}
+		}
+
+		options = append(options, server.WithImageWidths(widths...))
+
+		if u := viper.GetString("server.public_url"); len(u) > 0 {
+			options = append(options, server.WithPublicURL(u))
+		}
+
+		// Limit what pages may do, in case a book gets something past the sanitiser
+		if viper.GetBool("server.security.enabled") {
+			options = append(options, server.WithSecurity(middleware.Security{
+				ContentSecurityPolicy: viper.GetString("server.security.content_security_policy"),
+				FrameAncestors:        viper.GetString("server.security.frame_ancestors"),
+				ReferrerPolicy:        viper.GetString("server.security.referrer_policy"),
+				PermissionsPolicy:     viper.GetString("server.security.permissions_policy"),
+				HSTSMaxAge:            viper.GetDuration("server.security.hsts.max_age"),
+				HSTSIncludeSubdomains: viper.GetBool("server.security.hsts.include_subdomains"),
+			}))
+		}
+
+		// Remember what readers do, if there is somewhere to remember it
+		if path := viper.GetString("store.path"); len(path) > 0 {
+			options = append(options, server.WithStore(path))
 		}
 
 		// Add auth, if set
@@ -61,6 +140,14 @@ var serveCmd = &cobra.Command{
 			}))
 		}
 
+		// Clients that cannot sign in with the OIDC provider, such as e-reader apps, may use credentials instead
+		users := viper.GetStringMapString("server.authentication.basic.users")
+		tokens := viper.GetStringMapString("server.authentication.tokens")
+
+		if len(users) > 0 || len(tokens) > 0 {
+			options = append(options, server.WithCredentials(users, tokens))
+		}
+
 		srv, err := server.New(options...)
 
 		if err != nil {
@@ -75,6 +162,138 @@ var serveCmd = &cobra.Command{
 	},
 }
 
+// trustOptions reads how far the content of each book is trusted from the configuration
+func trustOptions() ([]server.Option, error) {
+	trust, err := book.ParseTrust(viper.GetString("book.trust.default"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	options := []server.Option{server.WithTrust(trust)}
+
+	for slug, name := range viper.GetStringMapString("book.trust.books") {
+		t, err := book.ParseTrust(name)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid trust level for book %s", slug)
+		}
+
+		options = append(options, server.WithTrust(t, slug))
+	}
+
+	return options, nil
+}
+
+// bookTrust returns how far the content of the book addressed by slug is trusted, as configured for the server
+func bookTrust(slug string) (book.Trust, error) {
+	name := viper.GetString("book.trust.default")
+
+	if t, ok := viper.GetStringMapString("book.trust.books")[slug]; ok {
+		name = t
+	}
+
+	return book.ParseTrust(name)
+}
+
+// s3Config reads how to reach books in S3 from the configuration, falling back to the variables the AWS tools use
+func s3Config() book.S3Config {
+	setting := func(key string, env string) string {
+		if v := viper.GetString(key); len(v) > 0 {
+			return v
+		}
+
+		return os.Getenv(env)
+	}
+
+	return book.S3Config{
+		Endpoint:        viper.GetString("book.s3.endpoint"),
+		Region:          setting("book.s3.region", "AWS_REGION"),
+		AccessKeyID:     setting("book.s3.access_key_id", "AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: setting("book.s3.secret_access_key", "AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    setting("book.s3.session_token", "AWS_SESSION_TOKEN"),
+	}
+}
+
+// injectionOptions reads the markup that should be added to each page from the configuration
+func injectionOptions() []func(*book.Injection) error {
+	options := []func(*book.Injection) error{}
+
+	if viper.GetBool("book.inject.defaults") {
+		options = append(options, book.WithDefaults())
+	}
+
+	if id := viper.GetString("book.inject.analytics.google"); len(id) > 0 {
+		options = append(options, book.WithGoogleAnalytics(id))
+	}
+
+	if path := viper.GetString("book.inject.stylesheet"); len(path) > 0 {
+		options = append(options, book.WithStylesheet(path))
+	}
+
+	if dir := viper.GetString("book.inject.templates"); len(dir) > 0 {
+		options = append(options, book.WithTemplates(dir))
+	}
+
+	return append(
+		options,
+		book.WithHead(viper.GetString("book.inject.head")),
+		book.WithBody(viper.GetString("book.inject.body")),
+	)
+}
+
 func init() {
 	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().Duration("reload-interval", 5*time.Second, "How often to check books for changes. 0 disables reloading.")
+
+	serveCmd.Flags().Duration("fetch-interval", book.DefaultFetchInterval, "How often to check books at remote sources for changes.")
+
+	serveCmd.Flags().String("s3-endpoint", "", "The URL of an S3 compatible object store to fetch s3:// books from. Empty uses AWS.")
+
+	serveCmd.Flags().String("store-path", "", "Where to keep what readers do, such as their reading progress. Empty disables it.")
+
+	serveCmd.Flags().Bool("download", true, "Allow readers to download the EPUB file of each book.")
+
+	serveCmd.Flags().StringSlice("download-disabled", []string{}, "The slug of a book that may be read, but not downloaded. May be repeated.")
+
+	serveCmd.Flags().IntSlice("thumbnail-sizes", book.DefaultThumbnailSizes, "The sizes, in pixels, that thumbnails of covers are made to fit.")
+
+	serveCmd.Flags().String("thumbnail-path", "", "Where to keep thumbnails of covers once they are made. Empty keeps them in memory.")
+
+	serveCmd.Flags().IntSlice("image-widths", book.DefaultImageWidths, "The widths, in pixels, that smaller variants of images in books are offered at. 0 offers none.")
+
+	serveCmd.Flags().String("public-url", "", "Where readers reach the library, such as https://books.example.com, for pages that are shared elsewhere to link back to.")
+
+	serveCmd.Flags().Bool("security-headers", true, "Send headers that limit what pages may do, such as a Content-Security-Policy.")
+
+	serveCmd.Flags().String("content-security-policy", middleware.DefaultContentSecurityPolicy, fmt.Sprintf("The Content-Security-Policy of every response. Each %s is replaced with the nonce the library's scripts are given.", middleware.PolicyNonce))
+
+	serveCmd.Flags().String("frame-ancestors", middleware.DefaultFrameAncestors, "The sources allowed to show pages in frames, unless the Content-Security-Policy says otherwise.")
+
+	serveCmd.Flags().String("referrer-policy", middleware.DefaultReferrerPolicy, "The Referrer-Policy of every response.")
+
+	serveCmd.Flags().String("permissions-policy", middleware.DefaultPermissionsPolicy, "The Permissions-Policy of every response.")
+
+	serveCmd.Flags().Duration("hsts-max-age", middleware.DefaultHSTSMaxAge, "How long browsers that reach the library by HTTPS should only reach it that way. 0 disables it.")
+
+	serveCmd.Flags().Bool("hsts-include-subdomains", false, "Have browsers reach every subdomain by HTTPS too.")
+
+	viper.BindPFlag("library.reload_interval", serveCmd.Flags().Lookup("reload-interval"))
+	viper.BindPFlag("library.fetch_interval", serveCmd.Flags().Lookup("fetch-interval"))
+	viper.BindPFlag("book.s3.endpoint", serveCmd.Flags().Lookup("s3-endpoint"))
+	viper.BindPFlag("book.download.enabled", serveCmd.Flags().Lookup("download"))
+	viper.BindPFlag("book.download.disabled", serveCmd.Flags().Lookup("download-disabled"))
+	viper.BindPFlag("store.path", serveCmd.Flags().Lookup("store-path"))
+	viper.BindPFlag("book.thumbnails.sizes", serveCmd.Flags().Lookup("thumbnail-sizes"))
+	viper.BindPFlag("book.thumbnails.path", serveCmd.Flags().Lookup("thumbnail-path"))
+	viper.BindPFlag("book.images.widths", serveCmd.Flags().Lookup("image-widths"))
+	viper.BindPFlag("server.public_url", serveCmd.Flags().Lookup("public-url"))
+	viper.BindPFlag("server.security.enabled", serveCmd.Flags().Lookup("security-headers"))
+	viper.BindPFlag("server.security.content_security_policy", serveCmd.Flags().Lookup("content-security-policy"))
+	viper.BindPFlag("server.security.frame_ancestors", serveCmd.Flags().Lookup("frame-ancestors"))
+	viper.BindPFlag("server.security.referrer_policy", serveCmd.Flags().Lookup("referrer-policy"))
+	viper.BindPFlag("server.security.permissions_policy", serveCmd.Flags().Lookup("permissions-policy"))
+	viper.BindPFlag("server.security.hsts.max_age", serveCmd.Flags().Lookup("hsts-max-age"))
+	viper.BindPFlag("server.security.hsts.include_subdomains", serveCmd.Flags().Lookup("hsts-include-subdomains"))
 }
@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dedelala/sysexits"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"go.pkg.littleman.co/library/internal/book"
+)
+
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// validateCmd checks books for structural problems
+var validateCmd = &cobra.Command{
+	Use:   "validate [book...]",
+	Short: "Check books for structural problems before they are published",
+	Long: `Check books for structural problems before they are published.
+
+Each book may be a path, or an http(s):// or s3://bucket/key URL. Without any, the books that would be served are
+checked.
+
+Exits 0 if every book is valid, 65 (EX_DATAERR) if any is not, and 66 (EX_NOINPUT) if any cannot be read.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		format := viper.GetString("validate.format")
+
+		if format != formatText && format != formatJSON {
+			fmt.Printf("unable to validate: unknown format %s, must be %s or %s\n", format, formatText, formatJSON)
+			os.Exit(sysexits.Usage)
+		}
+
+		locations, err := bookLocations(args)
+
+		if err != nil {
+			fmt.Printf("unable to validate: %s\n", err)
+			os.Exit(sysexits.NoInput)
+		}
+
+		reports := []*book.Report{}
+		code := sysexits.OK
+
+		for _, loc := range locations {
+			report, err := validate(loc)
+
+			// Books that cannot be read are reported with the others, so that every book has a result
+			if err != nil {
+				report = &book.Report{
+					Path:   loc,
+					Errors: 1,
+					Issues: []book.Issue{{Severity: book.SeverityError, Code: "unreadable", Message: err.Error()}},
+				}
+
+				code = sysexits.NoInput
+			}
+
+			invalid := !report.Valid || (viper.GetBool("validate.strict") && report.Warnings > 0)
+
+			if invalid && code == sysexits.OK {
+				code = sysexits.DataErr
+			}
+
+			reports = append(reports, report)
+		}
+
+		if format == formatJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(reports)
+		} else {
+			for _, r := range reports {
+				printReport(r)
+			}
+		}
+
+		os.Exit(code)
+	},
+}
+
+// bookLocations returns the books named on the command line or, failing that, those that would be served
+func bookLocations(args []string) ([]string, error) {
+	if len(args) > 0 {
+		return args, nil
+	}
+
+	locations := []string{}
+
+	if dir := viper.GetString("library.path"); len(dir) > 0 {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.epub"))
+
+		if err != nil {
+			return nil, err
+		}
+
+		locations = append(locations, paths...)
+	}
+
+	if !viper.IsSet("library.path") || viper.IsSet("book.path") {
+		locations = append(locations, viper.GetStringSlice("book.path")...)
+	}
+
+	return locations, nil
+}
+
+// validate fetches the book at location, if need be, and checks it
+func validate(location string) (*book.Report, error) {
+	src, err := book.ParseSource(location, s3Config())
+
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := src.Fetch(book.Version{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Temporary {
+		defer os.Remove(f.Path)
+	}
+
+	report, err := book.Validate(f.Path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	report.Path = src.String()
+
+	return report, nil
+}
+
+// printReport describes what was found in a book for people to read
+func printReport(r *book.Report) {
+	if r.Valid {
+		fmt.Printf("%s: valid, %s\n", r.Path, plural(r.Warnings, "warning"))
+	} else {
+		fmt.Printf("%s: invalid, %s and %s\n", r.Path, plural(r.Errors, "error"), plural(r.Warnings, "warning"))
+	}
+
+	for _, i := range r.Issues {
+		where := ""
+
+		if len(i.Path) > 0 {
+			where = i.Path + ": "
+		}
+
+		fmt.Printf("  %-7s %-20s %s%s\n", i.Severity, i.Code, where, i.Message)
+	}
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().StringP("format", "f", formatText, "How to report what was found; text or json.")
+
+	validateCmd.Flags().Bool("strict", false, "Fail if there are any warnings, as well as errors.")
+
+	viper.BindPFlag("validate.format", validateCmd.Flags().Lookup("format"))
+	viper.BindPFlag("validate.strict", validateCmd.Flags().Lookup("strict"))
+}
@@ -0,0 +1,86 @@
+package book
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"golang.org/x/net/html"
+)
+
+// AnchorPrefix starts the id of every element the library assigns one to
+const AnchorPrefix = "p-"
+
+// anchor assigns an id to every passage of the document that lacks one, so that any passage can be linked to, and
+// returns the ids of every element in the document.
+//
+// Ids are derived from the text of the passage, so they survive the book being rebuilt, or passages being added or
+// removed around them, so long as the passage itself is unchanged. Passages with the same text are told apart by the
+// order they appear in.
+func anchor(doc *html.Node) map[string]bool {
+	ids := map[string]bool{}
+
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if id := attr(n, "id"); len(id) > 0 {
+				ids[id] = true
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+
+	collect(doc)
+
+	// Passages are found the same way the search index finds them, so that every one it indexes has its own anchor
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "head", "script", "style", "nav":
+				return
+			}
+
+			if blocks[n.Data] && !hasBlock(n) {
+				if t := text(n); len(t) > 0 && len(attr(n, "id")) == 0 {
+					id := anchorID(t, ids)
+
+					n.Attr = append(n.Attr, html.Attribute{Key: "id", Val: id})
+					ids[id] = true
+				}
+
+				return
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+
+	f(doc)
+
+	return ids
+}
+
+// anchorID returns the id for a passage with text, that is not already one of ids
+func anchorID(text string, ids map[string]bool) string {
+	sum := sha256.Sum256([]byte(text))
+	base := AnchorPrefix + hex.EncodeToString(sum[:4])
+
+	id := base
+
+	for i := 2; ids[id]; i++ {
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+
+	return id
+}
+
+// HasAnchor indicates whether the document at path, relative to the content root, has an element with id
+func (h Book) HasAnchor(path string, id string) bool {
+	return h.anchors[path][id]
+}
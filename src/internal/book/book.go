@@ -1,6 +1,17 @@
 package book
 
 import (
+	"archive/zip"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
 	"github.com/kapmahc/epub"
 	"github.com/pkg/errors"
 )
@@ -9,14 +20,114 @@ import (
 type Book struct {
 	// EPub is the actual book being served
 	EPub *epub.Book
+
+	// Path is where the book comes from; a path on disk, or the URL of a remote source
+	Path string
+
+	// Root is the directory within the archive that contains the package document, and that all content is
+	// relative to. It is empty if the package document is at the root of the archive.
+	Root string
+
+	// Slug is the URL safe name of the book, used to address it within a library
+	Slug string
+
+	// ModTime is the modification time of the book at its source when it was opened
+	ModTime time.Time
+
+	// Size is the size of the book when it was opened
+	Size int64
+
+	// Metadata is the description of the book from its package document
+	Metadata Metadata
+
+	// Spine is the reading order of the book
+	Spine []SpineItem
+
+	// Contents is the table of contents of the book
+	Contents []Contents
+
+	// Cover is the image on the front of the book, if it has one
+	Cover *Cover
+
+	// The title of each document in the table of contents, by path
+	titles map[string]string
+
+	// The markup added to each document as it is rendered
+	injection *Injection
+
+	// Where the API for books is, if there is one
+	api string
+
+	// Where images of the covers of books are, and the size of the one shown when a book is shared, if there are any
+	preview     string
+	previewSize int
+
+	// Whether the EPUB file of the book is withheld from readers
+	noDownload bool
+
+	// Documents that have been rendered ahead of time, by path relative to the content root
+	documents map[string]*Document
+
+	// The table of contents page, rendered ahead of time
+	contents *Document
+
+	// The ids of the elements in each rendered document, by path relative to the content root
+	anchors map[string]map[string]bool
+
+	// The text of the reading order, for searching
+	index *Index
+
+	// Where the book was fetched from, and which version of it was fetched
+	source  Source
+	version Version
+
+	// The book on disk, read directly so that files stored without compression can be served without buffering
+	file    *os.File
+	archive *zip.Reader
+
+	// Every file within the content root by path relative to it, the order they are in the archive, the directories
+	// they are in and the media type each is served as. Nothing outside the content root can be opened.
+	entries     map[string]*zip.File
+	names       []string
+	directories map[string]bool
+	types       map[string]string
+
+	// The copy of the book made by fetching it, if it could not be removed while open, which is removed once the book
+	// is closed
+	temporary string
+
+	// The widths images are made available at, the size of every image in the documents by path, and the variants of
+	// them that have been made
+	imageWidths []int
+	images      map[string]image.Config
+	variants    *variants
+
+	// How far the content of the book is trusted, and what was removed from it because it is not
+	trust     Trust
+	sanitised []Sanitised
+
+	// The requests using this version of the book, which is only closed once none are
+	users *users
+}
+
+// users counts the requests using a version of a book, so that it is not closed while any of them are reading from it
+type users struct {
+	mu      sync.Mutex
+	count   int
+	retired bool
+	closed  bool
 }
 
 // New creates a new Book entity
 func New(options ...func(*Book) error) (*Book, error) {
-	b := &Book{}
+	b := &Book{users: &users{}}
 
 	for _, o := range options {
 		if err := o(b); err != nil {
+			if b.EPub != nil {
+				b.Close()
+			}
+
 			return nil, errors.Wrap(err, "unable to persist option to Book")
 		}
 	}
@@ -26,20 +137,361 @@ func New(options ...func(*Book) error) (*Book, error) {
 		return nil, errors.New("cannot create http book: no book supplied")
 	}
 
+	// Anything opened by the options is no longer needed if the book is invalid
+	valid := false
+
+	defer func() {
+		if !valid {
+			b.Close()
+		}
+	}()
+
+	if b.injection == nil {
+		b.injection = &Injection{}
+	}
+
+	if b.imageWidths == nil {
+		b.imageWidths = DefaultImageWidths
+	}
+
+	b.images = map[string]image.Config{}
+	b.variants = &variants{kept: map[string]*Document{}}
+
+	// The content root is wherever the package document is, as declared by META-INF/container.xml
+	if len(b.EPub.Container.Rootfile.Path) == 0 {
+		return nil, errors.New("cannot create http book: no package document declared in META-INF/container.xml")
+	}
+
+	if b.Root = path.Dir(b.EPub.Container.Rootfile.Path); b.Root == "." {
+		b.Root = ""
+	}
+
+	b.locate()
+
+	if len(b.Slug) == 0 {
+		b.Slug = Slug(b.source.Name())
+	}
+
+	md, err := b.metadata()
+
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create http book")
+	}
+
+	b.Metadata = md
+	b.Spine = spine(b.EPub)
+	b.Contents = contents(b.EPub, b.Spine)
+	b.titles = titles(b.Contents)
+	b.Cover = cover(b.EPub)
+
+	if err := b.render(); err != nil {
+		return nil, errors.Wrap(err, "cannot create http book")
+	}
+
+	valid = true
+
 	return b, nil
 }
 
-// WithEPUB adds the book to the Book
+// WithEPUB adds the book at path on disk to the Book
 func WithEPUB(path string) func(*Book) error {
+	return WithSource(NewFileSource(path))
+}
+
+// WithSource adds the book fetched from src to the Book
+func WithSource(src Source) func(*Book) error {
+	return func(h *Book) error {
+		f, err := src.Fetch(Version{})
+
+		if err != nil {
+			return errors.Wrap(err, "unable to open book")
+		}
+
+		return withFetched(src, f)(h)
+	}
+}
+
+// withFetched adds a book that has already been fetched from src to the Book
+func withFetched(src Source, f *Fetched) func(*Book) error {
 	return func(h *Book) error {
-		book, err := epub.Open(path)
+		// Until the book is opened, the copy of it is not closed with it
+		abandon := func() {
+			if f.Temporary {
+				os.Remove(f.Path)
+			}
+		}
+
+		book, err := epub.Open(f.Path)
+
+		if err != nil {
+			abandon()
+			return errors.Wrap(err, "unable to open book")
+		}
+
+		file, err := os.Open(f.Path)
 
 		if err != nil {
+			book.Close()
+			abandon()
+			return errors.Wrap(err, "unable to open book")
+		}
+
+		// The size is that of the version fetched, so that if a file changes while it is being read, it is picked up
+		// as changed again.
+		archive, err := zip.NewReader(file, f.Version.Size)
+
+		if err != nil {
+			book.Close()
+			file.Close()
+			abandon()
 			return errors.Wrap(err, "unable to open book")
 		}
 
 		h.EPub = book
+		h.file = file
+		h.archive = archive
+		h.source = src
+		h.version = f.Version
+		h.Path = src.String()
+		h.ModTime = f.Version.ModTime
+		h.Size = f.Version.Size
+
+		// A copy made by fetching the book is removed as soon as it is open, so that it is not left behind however the
+		// server stops. It is kept until the book is closed on systems that cannot remove files that are open.
+		if f.Temporary && os.Remove(f.Path) != nil {
+			h.temporary = f.Path
+		}
+
+		return nil
+	}
+}
+
+// WithSlug sets the name the book is addressed by
+func WithSlug(slug string) func(*Book) error {
+	return func(h *Book) error {
+		h.Slug = slug
+
+		return nil
+	}
+}
+
+// WithAPI declares where the API for books is, so that pages can use it to record things like reading progress. The
+// slug of the book is appended to prefix.
+func WithAPI(prefix string) func(*Book) error {
+	return func(h *Book) error {
+		h.api = prefix
 
 		return nil
 	}
 }
+
+// API returns where the API for this book is, or nothing if there is no API
+func (h Book) API() string {
+	if len(h.api) == 0 {
+		return ""
+	}
+
+	return h.api + "/" + h.Slug
+}
+
+// WithPreview declares where images of the covers of books are, so that the book can be shown with its cover when it
+// is shared. The slug of the book is appended to prefix, followed by size for covers that have thumbnails.
+func WithPreview(prefix string, size int) func(*Book) error {
+	return func(h *Book) error {
+		h.preview = prefix
+		h.previewSize = size
+
+		return nil
+	}
+}
+
+// Preview returns the image the book is shown with when it is shared, or nothing if there is none
+func (h Book) Preview() string {
+	if len(h.preview) == 0 || h.Cover == nil {
+		return ""
+	}
+
+	if Thumbnailable(&h) {
+		return fmt.Sprintf("%s/%s/%d", h.preview, h.Slug, h.previewSize)
+	}
+
+	return h.preview + "/" + h.Slug
+}
+
+// Title returns the title of the book, falling back to its slug if the book does not declare one
+func (h Book) Title() string {
+	for _, t := range h.EPub.Opf.Metadata.Title {
+		if len(t) > 0 {
+			return t
+		}
+	}
+
+	return h.Slug
+}
+
+// locate finds every file within the content root. Files whose names are not clean could be mistaken for files
+// elsewhere, so are left out.
+func (h *Book) locate() {
+	h.entries = map[string]*zip.File{}
+	h.names = []string{}
+	h.directories = map[string]bool{}
+	h.types = map[string]string{}
+
+	prefix := ""
+
+	if len(h.Root) > 0 {
+		prefix = h.Root + "/"
+	}
+
+	for _, f := range h.archive.File {
+		if !strings.HasPrefix(f.Name, prefix) || strings.HasSuffix(f.Name, "/") || path.Clean("/"+f.Name) != "/"+f.Name {
+			continue
+		}
+
+		name := strings.TrimPrefix(f.Name, prefix)
+
+		if _, ok := h.entries[name]; ok {
+			continue
+		}
+
+		h.entries[name] = f
+		h.names = append(h.names, name)
+		h.types[name] = mediaType(f)
+
+		for d := path.Dir(name); d != "."; d = path.Dir(d) {
+			h.directories[d] = true
+		}
+	}
+}
+
+// clean returns name, relative to the content root, in the form files are located by. It cannot climb above the
+// content root.
+func clean(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// Exists checks whether there is a file at name, relative to the content root
+func (h Book) Exists(name string) bool {
+	_, ok := h.entries[clean(name)]
+
+	return ok
+}
+
+// Open opens the file at name, relative to the content root
+func (h Book) Open(name string) (io.ReadCloser, error) {
+	f, ok := h.entries[clean(name)]
+
+	if !ok {
+		return nil, errors.Errorf("cannot open %s: not in book", name)
+	}
+
+	r, err := f.Open()
+
+	return r, errors.Wrapf(err, "cannot open %s", name)
+}
+
+// WithoutDownload withholds the EPUB file of the book from readers, such as for drafts that should not leave the
+// library
+func WithoutDownload() func(*Book) error {
+	return func(h *Book) error {
+		h.noDownload = true
+
+		return nil
+	}
+}
+
+// Downloadable indicates whether readers may download the EPUB file of the book
+func (h Book) Downloadable() bool {
+	return !h.noDownload
+}
+
+// Filename is the name the EPUB file of the book is offered to readers under; its title and first creator, as declared
+// in its package document.
+func (h Book) Filename() string {
+	name := h.Metadata.Title
+
+	if len(h.Metadata.Creators) > 0 && len(h.Metadata.Creators[0].Name) > 0 {
+		name += " - " + h.Metadata.Creators[0].Name
+	}
+
+	// Leave out anything that is not allowed in a file name on common file systems
+	name = strings.Join(strings.Fields(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return ' '
+		}
+
+		return r
+	}, name)), " ")
+
+	if len(name) == 0 {
+		name = h.Slug
+	}
+
+	return name + extTypeEPUB
+}
+
+// Original returns the EPUB file of the book, as it was on disk when it was opened
+func (h Book) Original() io.ReadSeeker {
+	return io.NewSectionReader(h.file, 0, h.Size)
+}
+
+// acquire records that a request is using the book. It must be released once the request is done with it.
+func (h Book) acquire() {
+	h.users.mu.Lock()
+	h.users.count++
+	h.users.mu.Unlock()
+}
+
+// Release records that a request acquired from the library is done with the book, closing it if it has been replaced
+// and this was the last request using it
+func (h Book) Release() {
+	h.users.mu.Lock()
+	defer h.users.mu.Unlock()
+
+	h.users.count--
+
+	if h.users.retired && h.users.count == 0 {
+		h.closeOnce()
+	}
+}
+
+// retire closes the book once the last request using it is done, or after ReloadGracePeriod whether or not they are,
+// so that a request that never finishes cannot keep it open forever
+func (h Book) retire() {
+	h.users.mu.Lock()
+	defer h.users.mu.Unlock()
+
+	h.users.retired = true
+
+	if h.users.count == 0 {
+		h.closeOnce()
+		return
+	}
+
+	time.AfterFunc(ReloadGracePeriod, func() {
+		h.users.mu.Lock()
+		defer h.users.mu.Unlock()
+
+		h.closeOnce()
+	})
+}
+
+// closeOnce closes the book unless it has been already. The users must be locked.
+func (h Book) closeOnce() {
+	if h.users.closed {
+		return
+	}
+
+	h.users.closed = true
+	h.Close()
+}
+
+// Close releases the resources associated with the book
+func (h Book) Close() {
+	h.EPub.Close()
+	h.file.Close()
+
+	if len(h.temporary) > 0 {
+		os.Remove(h.temporary)
+	}
+}
@@ -0,0 +1,121 @@
+package book
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testBook opens a book made of files, as testEPUB writes them
+func testBook(t *testing.T, files map[string]string, options ...func(*Book) error) *Book {
+	t.Helper()
+
+	b, err := New(append([]func(*Book) error{WithEPUB(testEPUB(t, files))}, options...)...)
+
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	t.Cleanup(b.Close)
+
+	return b
+}
+
+// testEPUB writes a book made of files, by path relative to the content root, and returns where it is. Every file is
+// in the manifest, and every XHTML document is in the reading order, by name.
+func testEPUB(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "library-test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	p := filepath.Join(dir, "book.epub")
+	f, err := os.Create(p)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(files))
+
+	for name := range files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	manifest := &strings.Builder{}
+	spine := &strings.Builder{}
+
+	for i, name := range names {
+		mt := mime.TypeByExtension(path.Ext(name))
+
+		if path.Ext(name) == ".xhtml" {
+			mt = mediaTypeXHTML
+			fmt.Fprintf(spine, `<itemref idref="item%d"/>`, i)
+		}
+
+		fmt.Fprintf(manifest, `<item id="item%d" href="%s" media-type="%s"/>`, i, name, mt)
+	}
+
+	content := map[string]string{
+		"mimetype": mediaTypeEPUB,
+		"META-INF/container.xml": `<?xml version="1.0"?>` +
+			`<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container"><rootfiles>` +
+			`<rootfile full-path="EPUB/content.opf" media-type="application/oebps-package+xml"/>` +
+			`</rootfiles></container>`,
+		"EPUB/content.opf": `<?xml version="1.0"?>` +
+			`<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">` +
+			`<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Test Book</dc:title>` +
+			`<dc:identifier id="id">urn:uuid:test</dc:identifier><dc:language>en</dc:language></metadata>` +
+			`<manifest>` + manifest.String() + `</manifest><spine>` + spine.String() + `</spine></package>`,
+	}
+
+	z := zip.NewWriter(f)
+
+	for _, name := range []string{"mimetype", "META-INF/container.xml", "EPUB/content.opf"} {
+		w, err := z.Create(name)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w.Write([]byte(content[name]))
+	}
+
+	for _, name := range names {
+		w, err := z.Create("EPUB/" + name)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w.Write([]byte(files[name]))
+	}
+
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Close()
+
+	return p
+}
+
+// testDocument returns an XHTML document with body as its content
+func testDocument(body string) string {
+	return `<?xml version="1.0" encoding="utf-8"?><!DOCTYPE html>` +
+		`<html xmlns="http://www.w3.org/1999/xhtml"><head><title>Test</title></head><body>` + body + `</body></html>`
+}
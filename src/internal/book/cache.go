@@ -0,0 +1,141 @@
+package book
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+// Document is a document from the book that has been rendered ahead of time, ready to be served
+type Document struct {
+	// Content is the rendered document
+	Content []byte
+
+	// ETag is a strong entity tag identifying this version of the document
+	ETag string
+
+	// Whether the document has scripts that must be given the nonce of each request it is served to
+	nonced bool
+}
+
+// newDocument returns the document with the rendered content
+func newDocument(content []byte) *Document {
+	return &Document{
+		Content: content,
+		ETag:    etag(content),
+		nonced:  bytes.Contains(content, nonceAttribute),
+	}
+}
+
+// render transforms every XHTML document in the book ahead of time, so that serving one is only a matter of copying
+// it from memory.
+func (h *Book) render() error {
+	h.documents = map[string]*Document{}
+	h.anchors = map[string]map[string]bool{}
+	h.index = newIndex()
+
+	buf := &bytes.Buffer{}
+
+	if err := h.renderContents(buf, strings.TrimPrefix(PathSearch, "/")); err != nil {
+		return err
+	}
+
+	h.contents = newDocument(buf.Bytes())
+
+	// Unless the book is trusted, anything in it that could run a script is removed before it is served
+	s := newSanitiser(h.trust)
+
+	// What is rendered is decided by the type each file is served as, not its extension, as that is what browsers go by
+	for _, name := range h.files() {
+		contentType := h.types[name]
+
+		// A cover the book declares to be an SVG image is served as one, whatever it is called
+		if h.Cover != nil && clean(h.Cover.Path) == name && baseType(h.Cover.MediaType) == mediaTypeSVG {
+			contentType = mediaTypeSVG
+		}
+
+		if baseType(contentType) == mediaTypeSVG && h.trust != TrustTrusted {
+			if err := h.renderSVG(name, s); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if !document(contentType) {
+			continue
+		}
+
+		f, err := h.Open(name)
+
+		if err != nil {
+			return errors.Wrapf(err, "unable to render %s", name)
+		}
+
+		doc, err := html.Parse(f)
+		f.Close()
+
+		if err != nil {
+			return errors.Wrapf(err, "unable to parse %s", name)
+		}
+
+		if h.trust != TrustTrusted {
+			s.html(name, doc)
+		}
+
+		h.responsive(name, doc)
+		h.anchors[name] = anchor(doc)
+
+		// Only the reading order is searchable. It is indexed after passages have anchors, so that every result can
+		// be linked to, but before the library adds the rest of its own markup.
+		if _, ok := h.Chapter(name); ok {
+			h.index.add(name, h.titles[name], doc)
+		}
+
+		buf := &bytes.Buffer{}
+
+		if err := h.renderHTML(name, doc, buf); err != nil {
+			return errors.Wrapf(err, "unable to render %s", name)
+		}
+
+		h.documents[name] = newDocument(buf.Bytes())
+	}
+
+	h.sanitised = s.found
+
+	return nil
+}
+
+// renderSVG sanitises the image at name ahead of time, as it may run scripts as any document can
+func (h *Book) renderSVG(name string, s *sanitiser) error {
+	f, err := h.Open(name)
+
+	if err != nil {
+		return errors.Wrapf(err, "unable to render %s", name)
+	}
+
+	defer f.Close()
+
+	content := s.svg(name, f)
+
+	h.documents[name] = newDocument(content)
+
+	return nil
+}
+
+// files returns the name of every file within the content root, relative to it, in the order they are in the archive
+func (h Book) files() []string {
+	return h.names
+}
+
+// etag returns a strong entity tag for content
+func etag(content []byte) string {
+	sum := sha256.Sum256(content)
+
+	return fmt.Sprintf("%q", hex.EncodeToString(sum[:16]))
+}
@@ -0,0 +1,440 @@
+package book
+
+import (
+	"html/template"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/kapmahc/epub"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+const (
+	propertyNav = "nav"
+	epubTypeTOC = "toc"
+)
+
+// Contents is an entry in the table of contents of a book
+type Contents struct {
+	// Title is the label of this entry
+	Title string `json:"title"`
+
+	// Href is a reference to this entry relative to the content root, including any fragment
+	Href string `json:"href,omitempty"`
+
+	// Children are the entries nested under this entry
+	Children []Contents `json:"children,omitempty"`
+}
+
+// SpineItem is a document in the reading order of a book
+type SpineItem struct {
+	// ID is the identifier of the item in the manifest
+	ID string `json:"id"`
+
+	// Path is the location of the document relative to the content root
+	Path string `json:"path"`
+
+	// MediaType is the declared type of the document
+	MediaType string `json:"media_type"`
+
+	// Linear is whether the document is part of the primary reading order, rather than auxiliary content
+	Linear bool `json:"linear"`
+}
+
+var contentsTemplate = template.Must(template.New("contents").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>{{ .Title }}</title>
+	<meta property="og:type" content="book">
+	<meta property="og:title" content="{{ .Title }}">
+	{{- with .Preview }}
+	<meta property="og:image" content="{{ . }}">
+	{{- end }}
+	{{ .Head }}
+</head>
+<body>
+	<h1>{{ .Title }}</h1>
+	<p class="library-continue" hidden><a href="./">Continue reading</a></p>
+	<form action="{{ .Search }}" method="get" class="library-search">
+		<input type="search" name="q" placeholder="Search this book">
+		<button type="submit">Search</button>
+	</form>
+	{{- with .Start }}
+	<p><a href="{{ . }}">Start reading</a></p>
+	{{- end }}
+	<nav>
+	{{- template "list" .Contents }}
+	</nav>
+	{{ .Body }}
+</body>
+</html>
+{{- define "list" }}
+	<ol>
+	{{- range . }}
+		<li>
+			{{- if .Href }}<a href="{{ .Href }}">{{ .Title }}</a>{{ else }}<span>{{ .Title }}</span>{{ end }}
+			{{- with .Children }}{{ template "list" . }}{{ end }}
+		</li>
+	{{- end }}
+	</ol>
+{{- end }}
+`))
+
+// resolve returns the location of ref relative to the content root, given the document it was found in.
+func resolve(from string, ref string) (*url.URL, error) {
+	u, err := url.Parse(ref)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to resolve %s", ref)
+	}
+
+	resolved := (&url.URL{Path: "/" + from}).ResolveReference(u)
+	resolved.Path = strings.TrimPrefix(resolved.Path, "/")
+	resolved.RawPath = ""
+
+	return resolved, nil
+}
+
+// relative returns a reference to target that is valid from within the document at from.
+func relative(from string, target *url.URL) string {
+	ref := strings.Repeat("../", strings.Count(from, "/")) + (&url.URL{Path: target.Path}).EscapedPath()
+
+	if len(ref) == 0 {
+		ref = "./"
+	}
+
+	// A path segment containing a colon would otherwise be mistaken for a scheme
+	if i := strings.IndexAny(ref, ":/"); i >= 0 && ref[i] == ':' {
+		ref = "./" + ref
+	}
+
+	if len(target.Fragment) > 0 {
+		ref += "#" + url.PathEscape(target.Fragment)
+	}
+
+	return ref
+}
+
+// spine returns the reading order of the book, with each document located relative to the content root
+func spine(b *epub.Book) []SpineItem {
+	manifest := map[string]epub.Manifest{}
+
+	for _, m := range b.Opf.Manifest {
+		manifest[m.ID] = m
+	}
+
+	items := []SpineItem{}
+
+	for _, i := range b.Opf.Spine.Items {
+		m, ok := manifest[i.IDref]
+
+		if !ok {
+			continue
+		}
+
+		u, err := resolve("", m.Href)
+
+		if err != nil {
+			continue
+		}
+
+		items = append(items, SpineItem{
+			ID:        m.ID,
+			Path:      u.Path,
+			MediaType: m.MediaType,
+			Linear:    i.Linear != "no",
+		})
+	}
+
+	return items
+}
+
+// contents returns the table of contents of the book. It prefers the EPUB 3 navigation document, falls back to the
+// EPUB 2 NCX, and failing both lists the spine.
+func contents(b *epub.Book, items []SpineItem) []Contents {
+	for _, m := range b.Opf.Manifest {
+		if !hasProperty(m.Properties, propertyNav) {
+			continue
+		}
+
+		if c, err := navDocument(b, m.Href); err == nil && len(c) > 0 {
+			return c
+		}
+
+		break
+	}
+
+	if len(b.Ncx.Points) > 0 {
+		for _, m := range b.Opf.Manifest {
+			if m.ID != b.Opf.Spine.Toc {
+				continue
+			}
+
+			if u, err := resolve("", m.Href); err == nil {
+				return ncxContents(u.Path, b.Ncx.Points)
+			}
+		}
+	}
+
+	c := []Contents{}
+
+	for _, i := range items {
+		if !i.Linear {
+			continue
+		}
+
+		c = append(c, Contents{
+			Title: i.Path,
+			Href:  (&url.URL{Path: i.Path}).String(),
+		})
+	}
+
+	return c
+}
+
+func navDocument(b *epub.Book, href string) ([]Contents, error) {
+	u, err := resolve("", href)
+
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := b.Open(u.Path)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open navigation document")
+	}
+
+	defer f.Close()
+
+	return navContents(u.Path, f)
+}
+
+func ncxContents(from string, points []epub.NavPoint) []Contents {
+	c := []Contents{}
+
+	for _, p := range points {
+		u, err := resolve(from, p.Content.Src)
+
+		if err != nil {
+			continue
+		}
+
+		c = append(c, Contents{
+			Title:    strings.TrimSpace(p.Text),
+			Href:     u.String(),
+			Children: ncxContents(from, p.Points),
+		})
+	}
+
+	return c
+}
+
+// navContents reads the table of contents out of an EPUB 3 navigation document located at from
+func navContents(from string, r io.Reader) ([]Contents, error) {
+	doc, err := html.Parse(r)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse navigation document")
+	}
+
+	// Find the nav that is the table of contents, falling back to the first nav in the document.
+	var nav *html.Node
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "nav" {
+			if hasProperty(epubType(n), epubTypeTOC) && (nav == nil || !hasProperty(epubType(nav), epubTypeTOC)) {
+				nav = n
+			}
+
+			if nav == nil {
+				nav = n
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+
+	f(doc)
+
+	if nav == nil {
+		return nil, errors.New("navigation document has no nav element")
+	}
+
+	for c := nav.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == "ol" {
+			return navList(from, c), nil
+		}
+	}
+
+	return nil, errors.New("navigation document has no list of contents")
+}
+
+func navList(from string, ol *html.Node) []Contents {
+	c := []Contents{}
+
+	for li := ol.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.Data != "li" {
+			continue
+		}
+
+		entry := Contents{}
+
+		for n := li.FirstChild; n != nil; n = n.NextSibling {
+			if n.Type != html.ElementNode {
+				continue
+			}
+
+			switch n.Data {
+			case "a":
+				entry.Title = text(n)
+
+				if u, err := resolve(from, attr(n, "href")); err == nil {
+					entry.Href = u.String()
+				}
+			case "span":
+				entry.Title = text(n)
+			case "ol":
+				entry.Children = navList(from, n)
+			}
+		}
+
+		c = append(c, entry)
+	}
+
+	return c
+}
+
+// Chapter returns the item in the reading order at path, relative to the content root, if there is one
+func (h Book) Chapter(path string) (SpineItem, bool) {
+	for _, i := range h.Spine {
+		if i.Path == path {
+			return i, true
+		}
+	}
+
+	return SpineItem{}, false
+}
+
+// ChapterTitle returns the title of the document at path in the table of contents, or its path if it is not listed
+func (h Book) ChapterTitle(path string) string {
+	if t, ok := h.titles[path]; ok && len(t) > 0 {
+		return t
+	}
+
+	return path
+}
+
+// titles returns the title of each document in the table of contents, keyed by its path
+func titles(c []Contents) map[string]string {
+	t := map[string]string{}
+
+	var f func([]Contents)
+	f = func(c []Contents) {
+		for _, e := range c {
+			if u, err := url.Parse(e.Href); err == nil && len(e.Href) > 0 {
+				if _, ok := t[u.Path]; !ok {
+					t[u.Path] = e.Title
+				}
+			}
+
+			f(e.Children)
+		}
+	}
+
+	f(c)
+
+	return t
+}
+
+func hasProperty(properties string, property string) bool {
+	for _, p := range strings.Fields(properties) {
+		if p == property {
+			return true
+		}
+	}
+
+	return false
+}
+
+func epubType(n *html.Node) string {
+	for _, a := range n.Attr {
+		if a.Key == "epub:type" || (a.Namespace == "epub" && a.Key == "type") {
+			return a.Val
+		}
+	}
+
+	return ""
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+
+	return ""
+}
+
+func text(n *html.Node) string {
+	b := strings.Builder{}
+
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+
+	f(n)
+
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// renderContents renders a page listing the table of contents of the book, searching it with the page at search
+func (h Book) renderContents(w io.Writer, search string) error {
+	start := ""
+
+	for _, i := range h.Spine {
+		if i.Linear {
+			start = relative("", &url.URL{Path: i.Path})
+			break
+		}
+	}
+
+	head, body, err := h.injection.html(h.API(), "")
+
+	if err != nil {
+		return errors.Wrap(err, "unable to render table of contents")
+	}
+
+	err = contentsTemplate.Execute(w, struct {
+		Title    string
+		Start    string
+		Contents []Contents
+		Search   string
+		Preview  string
+		Head     template.HTML
+		Body     template.HTML
+	}{
+		Search:   search,
+		Preview:  h.Preview(),
+		Title:    h.Title(),
+		Start:    start,
+		Contents: h.Contents,
+		Head:     head,
+		Body:     body,
+	})
+
+	return errors.Wrap(err, "unable to render table of contents")
+}
@@ -0,0 +1,54 @@
+package book
+
+import (
+	"strings"
+
+	"github.com/kapmahc/epub"
+)
+
+const (
+	propertyCoverImage = "cover-image"
+	metaNameCover      = "cover"
+)
+
+// Cover is the image on the front of a book
+type Cover struct {
+	// Path is the location of the image, relative to the content root
+	Path string `json:"path"`
+
+	// MediaType is the type of the image, as declared in the manifest
+	MediaType string `json:"media_type"`
+}
+
+// cover finds the cover image of the book. EPUB 3 marks it with the cover-image property in the manifest, and EPUB 2
+// with a meta element naming its manifest item.
+func cover(b *epub.Book) *Cover {
+	id := ""
+
+	for _, m := range b.Opf.Metadata.Meta {
+		if m.Name == metaNameCover {
+			id = m.Content
+		}
+	}
+
+	for _, m := range b.Opf.Manifest {
+		if !hasProperty(m.Properties, propertyCoverImage) && (len(id) == 0 || m.ID != id) {
+			continue
+		}
+
+		// Some books name a cover that is not an image, such as the document it is displayed on
+		if !strings.HasPrefix(m.MediaType, "image/") {
+			continue
+		}
+
+		u, err := resolve("", m.Href)
+
+		if err != nil {
+			continue
+		}
+
+		return &Cover{Path: u.Path, MediaType: m.MediaType}
+	}
+
+	return nil
+}
@@ -0,0 +1,681 @@
+package book
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+const (
+	// ExportIndex is the file the table of contents of an exported book is written to, so that static hosts serve it
+	// at the root of the book as the server does
+	ExportIndex = "index.html"
+
+	// ExportSearch is the page that searches an exported book, in the browser
+	ExportSearch = "search.html"
+
+	// ExportSearchIndex is the file the passages of an exported book are written to, for the search page to search
+	ExportSearchIndex = "search.json"
+)
+
+// cssURL matches the references to other files in a stylesheet
+var cssURL = regexp.MustCompile(`url\(\s*(['"]?)([^'")]+)(['"]?)\s*\)`)
+
+var exportSearchTemplate = template.Must(template.New("search").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Search: {{ .Title }}</title>
+	{{ .Head }}
+</head>
+<body>
+	<h1><a href="./">{{ .Title }}</a></h1>
+	<form action="{{ .Search }}" method="get" class="library-search">
+		<input type="search" name="q" placeholder="Search this book">
+		<button type="submit">Search</button>
+	</form>
+	<div class="library-search-status"></div>
+	{{ .Body }}
+	<script>{{ .Script }}</script>
+</body>
+</html>
+`))
+
+// exportSearchScript searches the passages of an exported book the same way the server does; passages that contain
+// more of the terms first, then those in which the terms are rarer and more frequent.
+const exportSearchScript = `
+(function () {
+	var limit = %d, before = %d, after = %d;
+	var query = (new URLSearchParams(window.location.search).get("q") || "").trim();
+	var input = document.querySelector(".library-search input");
+	var status = document.querySelector(".library-search-status");
+
+	input.value = query;
+
+	if (query.length === 0) {
+		return;
+	}
+
+	var tokenize = function (text) {
+		return text.toLowerCase().split(/[^\p{L}\p{N}]+/u).filter(function (t) { return t.length > 0; });
+	};
+
+	var snippet = function (text, terms) {
+		var words = text.split(/\s+/).filter(function (w) { return w.length > 0; });
+		var marked = words.map(function (w) {
+			return tokenize(w).some(function (t) { return terms.indexOf(t) >= 0; });
+		});
+		var first = Math.max(marked.indexOf(true), 0);
+		var start = Math.max(first - before, 0), end = Math.min(first + after, words.length);
+		var p = document.createElement("p");
+
+		if (start > 0) {
+			p.appendChild(document.createTextNode("… "));
+		}
+
+		for (var n = start; n < end; n++) {
+			if (n > start) {
+				p.appendChild(document.createTextNode(" "));
+			}
+
+			if (marked[n]) {
+				var mark = document.createElement("mark");
+				mark.textContent = words[n];
+				p.appendChild(mark);
+			} else {
+				p.appendChild(document.createTextNode(words[n]));
+			}
+		}
+
+		if (end < words.length) {
+			p.appendChild(document.createTextNode(" …"));
+		}
+
+		return p;
+	};
+
+	fetch("` + ExportSearchIndex + `").then(function (response) {
+		return response.json();
+	}).then(function (passages) {
+		var postings = {};
+
+		passages.forEach(function (p, n) {
+			var counts = {};
+
+			tokenize(p.text).forEach(function (t) {
+				counts[t] = (counts[t] || 0) + 1;
+			});
+
+			Object.keys(counts).forEach(function (t) {
+				(postings[t] = postings[t] || []).push({passage: n, count: counts[t]});
+			});
+		});
+
+		var terms = tokenize(query).filter(function (t, n, all) { return all.indexOf(t) === n; });
+		var scores = {}, matched = {};
+
+		terms.forEach(function (t) {
+			var found = postings[t] || [];
+
+			if (found.length === 0) {
+				return;
+			}
+
+			var idf = Math.log(1 + passages.length / found.length);
+
+			found.forEach(function (p) {
+				scores[p.passage] = (scores[p.passage] || 0) + p.count * idf;
+				matched[p.passage] = (matched[p.passage] || 0) + 1;
+			});
+		});
+
+		var highlight = new URLSearchParams({"` + QueryHighlight + `": terms.join(" ")}).toString();
+		var hits = Object.keys(scores).map(function (n) {
+			var p = passages[n];
+
+			return {
+				title: p.title,
+				href: p.href + "?" + highlight + p.fragment,
+				text: p.text,
+				score: scores[n] + matched[n] * terms.length
+			};
+		});
+
+		hits.sort(function (a, b) {
+			return b.score - a.score || (a.href < b.href ? -1 : a.href > b.href ? 1 : 0);
+		});
+
+		hits = hits.slice(0, limit);
+
+		var summary = document.createElement("p");
+		var em = document.createElement("em");
+		em.textContent = query;
+		summary.appendChild(document.createTextNode(hits.length + " result" + (hits.length === 1 ? "" : "s") + " for "));
+		summary.appendChild(em);
+		status.appendChild(summary);
+
+		var list = document.createElement("ol");
+		list.className = "library-search-results";
+
+		hits.forEach(function (hit) {
+			var li = document.createElement("li");
+			var a = document.createElement("a");
+			a.href = hit.href;
+			a.textContent = hit.title;
+			li.appendChild(a);
+			li.appendChild(snippet(hit.text, terms));
+			list.appendChild(li);
+		});
+
+		status.appendChild(list);
+	});
+})();
+`
+
+// exportPassage is a passage of an exported book, as the search page reads it
+type exportPassage struct {
+	Title    string `json:"title"`
+	Href     string `json:"href"`
+	Fragment string `json:"fragment"`
+	Text     string `json:"text"`
+}
+
+// Export writes the book out as a static website in dir, to be read just as it is when served; the table of contents
+// as ExportIndex, every document as it is rendered, every other file as it is in the book and a search page that
+// searches in the browser. As when served, the table of contents takes the place of any ExportIndex in the book.
+// There is no policy that scripts need a nonce for on a static host, so they are written without one. Images still
+// offer their smaller variants, but a static host serves them whole whichever width is asked for.
+func (h Book) Export(dir string) error {
+	for _, name := range h.files() {
+		target := exportPath(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return errors.Wrapf(err, "unable to export %s", name)
+		}
+
+		if d, ok := h.documents[name]; ok {
+			if err := ioutil.WriteFile(target, withNonce(d.Content, ""), 0644); err != nil {
+				return errors.Wrapf(err, "unable to export %s", name)
+			}
+
+			continue
+		}
+
+		if err := h.exportFile(name, target); err != nil {
+			return err
+		}
+	}
+
+	contents := &bytes.Buffer{}
+
+	if err := h.renderContents(contents, ExportSearch); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(exportPath(dir, ExportIndex), withNonce(contents.Bytes(), ""), 0644); err != nil {
+		return errors.Wrap(err, "unable to export table of contents")
+	}
+
+	return h.exportSearch(dir)
+}
+
+// exportFile copies the file at name, relative to the content root, to target
+func (h Book) exportFile(name string, target string) error {
+	r, err := h.Open(name)
+
+	if err != nil {
+		return errors.Wrapf(err, "unable to export %s", name)
+	}
+
+	defer r.Close()
+
+	w, err := os.Create(target)
+
+	if err != nil {
+		return errors.Wrapf(err, "unable to export %s", name)
+	}
+
+	_, err = io.Copy(w, r)
+
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+
+	return errors.Wrapf(err, "unable to export %s", name)
+}
+
+// exportSearch writes the passages of the book, and the page that searches them
+func (h Book) exportSearch(dir string) error {
+	passages := make([]exportPassage, 0, len(h.index.passages))
+
+	for _, p := range h.index.passages {
+		passages = append(passages, exportPassage{
+			Title:    p.title,
+			Href:     relative("", &url.URL{Path: p.path}),
+			Fragment: fragment(p.anchor),
+			Text:     p.text,
+		})
+	}
+
+	index, err := json.Marshal(passages)
+
+	if err != nil {
+		return errors.Wrap(err, "unable to export search")
+	}
+
+	if err := ioutil.WriteFile(exportPath(dir, ExportSearchIndex), index, 0644); err != nil {
+		return errors.Wrap(err, "unable to export search")
+	}
+
+	// An export is served without the library, so there is no API for anything to record what readers do with
+	head, body, err := h.injection.html("", "")
+
+	if err != nil {
+		return errors.Wrap(err, "unable to export search")
+	}
+
+	page := &bytes.Buffer{}
+
+	if err := exportSearchTemplate.Execute(page, struct {
+		Title  string
+		Search string
+		Head   template.HTML
+		Body   template.HTML
+		Script template.JS
+	}{
+		Title:  h.Title(),
+		Search: ExportSearch,
+		Head:   head,
+		Body:   body,
+		Script: template.JS(fmt.Sprintf(exportSearchScript, searchLimit, snippetBefore, snippetAfter)),
+	}); err != nil {
+		return errors.Wrap(err, "unable to export search")
+	}
+
+	return errors.Wrap(ioutil.WriteFile(exportPath(dir, ExportSearch), withNonce(page.Bytes(), ""), 0644), "unable to export search")
+}
+
+// exportPath returns where the file at name, relative to the content root, is written within dir. Names cannot climb
+// out of dir.
+func exportPath(dir string, name string) string {
+	return filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+// single is a book being written out as a single page
+type single struct {
+	book Book
+
+	// Every id in the page, so that those from different documents do not collide
+	ids map[string]bool
+
+	// The id each document was given in the page, and each id within it was renamed to, by path
+	sections map[string]string
+	targets  map[string]map[string]string
+
+	// Stylesheets already in the page, by path or content, so that each is only included once
+	styles map[string]bool
+
+	// Files already encoded as data URIs, by path
+	data map[string]string
+
+	// The declared type of each file, by path
+	types map[string]string
+}
+
+// ExportSingle writes the book out as a single HTML page; the table of contents followed by every document in reading
+// order, with their stylesheets and the files they embed inlined, and links between them made links within the page.
+func (h Book) ExportSingle(w io.Writer) error {
+	s := &single{
+		book:     h,
+		ids:      map[string]bool{},
+		sections: map[string]string{},
+		targets:  map[string]map[string]string{},
+		styles:   map[string]bool{},
+		data:     map[string]string{},
+		types:    map[string]string{},
+	}
+
+	for _, m := range h.Manifest() {
+		s.types[m.Path] = m.MediaType
+	}
+
+	element := func(tag string, attrs ...html.Attribute) *html.Node {
+		return &html.Node{Type: html.ElementNode, Data: tag, Attr: attrs}
+	}
+
+	root := element("html")
+
+	if len(h.Metadata.Language) > 0 {
+		root.Attr = append(root.Attr, html.Attribute{Key: "lang", Val: h.Metadata.Language[0]})
+	}
+
+	head := element("head")
+	body := element("body")
+	title := element("title")
+
+	title.AppendChild(&html.Node{Type: html.TextNode, Data: h.Title()})
+	head.AppendChild(element("meta", html.Attribute{Key: "charset", Val: "utf-8"}))
+	head.AppendChild(title)
+
+	heading := element("h1")
+	heading.AppendChild(&html.Node{Type: html.TextNode, Data: h.Title()})
+	body.AppendChild(heading)
+
+	nav := element("nav", html.Attribute{Key: "class", Val: "library-contents"})
+	body.AppendChild(nav)
+
+	sections := []*html.Node{}
+
+	for _, item := range h.Spine {
+		section, err := s.document(item.Path, head)
+
+		if err != nil {
+			return err
+		}
+
+		sections = append(sections, section)
+		body.AppendChild(section)
+	}
+
+	// Links can only be made once every document, and so every id, is in the page
+	for n, section := range sections {
+		s.links(h.Spine[n].Path, section)
+	}
+
+	nav.AppendChild(s.contents(h.Contents))
+
+	injected, err := h.injection.head("", "")
+
+	if err != nil {
+		return err
+	}
+
+	for _, n := range injected {
+		head.AppendChild(n)
+	}
+
+	injected, err = h.injection.body()
+
+	if err != nil {
+		return err
+	}
+
+	for _, n := range injected {
+		body.AppendChild(n)
+	}
+
+	root.AppendChild(head)
+	root.AppendChild(body)
+
+	doc := &html.Node{Type: html.DocumentNode}
+	doc.AppendChild(&html.Node{Type: html.DoctypeNode, Data: "html"})
+	doc.AppendChild(root)
+
+	return errors.Wrap(html.Render(w, doc), "unable to export book")
+}
+
+// document returns the body of the document at path as a section of the page, adding its stylesheets to head
+func (s *single) document(name string, head *html.Node) (*html.Node, error) {
+	f, err := s.book.Open(name)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to export %s", name)
+	}
+
+	doc, err := html.Parse(f)
+	f.Close()
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse %s", name)
+	}
+
+	if s.book.trust != TrustTrusted {
+		newSanitiser(s.book.trust).html(name, doc)
+	}
+
+	// Passages are given the same ids as when served, so that links to them work in either
+	anchor(doc)
+
+	section := &html.Node{Type: html.ElementNode, Data: "section", Attr: []html.Attribute{
+		{Key: "id", Val: s.id("library-" + Slug(name))},
+		{Key: "class", Val: "library-document"},
+	}}
+
+	s.sections[name] = attr(section, "id")
+	s.targets[name] = map[string]string{}
+
+	var walk func(n *html.Node)
+
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch {
+			case n.Data == "link" && strings.Contains(strings.ToLower(attr(n, "rel")), "stylesheet"):
+				s.stylesheet(name, attr(n, "href"), head)
+				return
+			case n.Data == "style":
+				if css := text(n); !s.styles[css] {
+					s.styles[css] = true
+					s.style(name, n.FirstChild, head)
+				}
+
+				return
+			case n.Data == "body":
+				for c := n.FirstChild; c != nil; {
+					next := c.NextSibling
+					n.RemoveChild(c)
+					section.AppendChild(c)
+					c = next
+				}
+
+				return
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+
+	walk(doc)
+
+	// Ids are made unique within the page, remembering what they became so that links to them can follow
+	var rename func(n *html.Node)
+
+	rename = func(n *html.Node) {
+		for i, a := range n.Attr {
+			if a.Key == "id" && len(a.Namespace) == 0 && n != section {
+				n.Attr[i].Val = s.id(a.Val)
+				s.targets[name][a.Val] = n.Attr[i].Val
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			rename(c)
+		}
+	}
+
+	rename(section)
+
+	return section, nil
+}
+
+// links makes the links in the section from the document at name point within the page, and embeds what it refers to
+func (s *single) links(name string, section *html.Node) {
+	var walk func(n *html.Node)
+
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			for i, a := range n.Attr {
+				switch {
+				case a.Key == "href" && len(a.Namespace) == 0 && (n.Data == "a" || n.Data == "area"):
+					n.Attr[i].Val = s.link(name, a.Val)
+				case a.Key == "src" || a.Key == "poster" || (a.Key == "href" && (a.Namespace == "xlink" || n.Data == "image")):
+					n.Attr[i].Val = s.embed(name, a.Val)
+				}
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+
+	walk(section)
+}
+
+// link returns where ref, from the document at name, points within the page. Links to anything other than a document
+// in the page embed what they point to instead.
+func (s *single) link(name string, ref string) string {
+	u, err := resolve(name, ref)
+
+	if err != nil || len(u.Scheme) > 0 || len(u.Host) > 0 {
+		return ref
+	}
+
+	section, ok := s.sections[u.Path]
+
+	if !ok {
+		return s.embed(name, ref)
+	}
+
+	if id, ok := s.targets[u.Path][u.Fragment]; ok && len(u.Fragment) > 0 {
+		return "#" + id
+	}
+
+	return "#" + section
+}
+
+// embed returns ref, from the document at name, as a data URI of the file it refers to, if it is in the book
+func (s *single) embed(name string, ref string) string {
+	u, err := resolve(name, ref)
+
+	if err != nil || len(u.Scheme) > 0 || len(u.Host) > 0 {
+		return ref
+	}
+
+	if uri, ok := s.data[u.Path]; ok {
+		return uri
+	}
+
+	f, err := s.book.Open(u.Path)
+
+	if err != nil {
+		return ref
+	}
+
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+
+	if err != nil {
+		return ref
+	}
+
+	t, ok := s.types[u.Path]
+
+	if !ok {
+		t = mime.TypeByExtension(path.Ext(u.Path))
+	}
+
+	s.data[u.Path] = "data:" + t + ";base64," + base64.StdEncoding.EncodeToString(content)
+
+	return s.data[u.Path]
+}
+
+// stylesheet adds the stylesheet at ref, from the document at name, to head
+func (s *single) stylesheet(name string, ref string, head *html.Node) {
+	u, err := resolve(name, ref)
+
+	if err != nil || len(u.Scheme) > 0 || len(u.Host) > 0 || s.styles[u.Path] {
+		return
+	}
+
+	s.styles[u.Path] = true
+
+	f, err := s.book.Open(u.Path)
+
+	if err != nil {
+		return
+	}
+
+	defer f.Close()
+
+	css, err := ioutil.ReadAll(f)
+
+	if err != nil {
+		return
+	}
+
+	s.style(u.Path, &html.Node{Type: html.TextNode, Data: string(css)}, head)
+}
+
+// style adds the stylesheet css, which was found in the file at name, to head with the files it refers to embedded
+func (s *single) style(name string, css *html.Node, head *html.Node) {
+	if css == nil {
+		return
+	}
+
+	content := cssURL.ReplaceAllStringFunc(css.Data, func(match string) string {
+		m := cssURL.FindStringSubmatch(match)
+
+		return "url(" + m[1] + s.embed(name, m[2]) + m[3] + ")"
+	})
+
+	style := &html.Node{Type: html.ElementNode, Data: "style", Attr: []html.Attribute{{Key: "type", Val: "text/css"}}}
+	style.AppendChild(&html.Node{Type: html.TextNode, Data: content})
+	head.AppendChild(style)
+}
+
+// contents returns the table of contents as a list of links within the page
+func (s *single) contents(entries []Contents) *html.Node {
+	ol := &html.Node{Type: html.ElementNode, Data: "ol"}
+
+	for _, c := range entries {
+		li := &html.Node{Type: html.ElementNode, Data: "li"}
+		label := &html.Node{Type: html.ElementNode, Data: "span"}
+
+		if len(c.Href) > 0 {
+			label = &html.Node{Type: html.ElementNode, Data: "a", Attr: []html.Attribute{
+				{Key: "href", Val: s.link("", c.Href)},
+			}}
+		}
+
+		label.AppendChild(&html.Node{Type: html.TextNode, Data: c.Title})
+		li.AppendChild(label)
+
+		if len(c.Children) > 0 {
+			li.AppendChild(s.contents(c.Children))
+		}
+
+		ol.AppendChild(li)
+	}
+
+	return ol
+}
+
+// id returns id, or if it is already in the page, the first of id-2, id-3 and so on that is not
+func (s *single) id(id string) string {
+	unique := id
+
+	for n := 2; s.ids[unique]; n++ {
+		unique = fmt.Sprintf("%s-%d", id, n)
+	}
+
+	s.ids[unique] = true
+
+	return unique
+}
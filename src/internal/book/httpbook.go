@@ -1,13 +1,22 @@
 package book
 
 import (
+	"archive/zip"
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime"
 	"net/http"
-	"path/filepath"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	"go.pkg.littleman.co/library/internal/nonce"
+	"go.pkg.littleman.co/library/internal/problems"
 	"golang.org/x/net/html"
 )
 
@@ -20,101 +29,280 @@ type HTTPBook struct {
 
 const (
 	extTypeXHTML = ".xhtml"
+	extTypeHTML  = ".html"
+	extTypeHTM   = ".htm"
+
+	pageTypeHTML = "text/html; charset=utf-8"
 )
 
+// directoryIndexes are the documents that are served for the directory they are in, in order of preference
+var directoryIndexes = []string{"index" + extTypeXHTML, "index" + extTypeHTML, "index" + extTypeHTM}
+
 // Handler is the HTTP handler that serves the appropriate book content
 func (h Book) Handler(w http.ResponseWriter, r *http.Request) {
-	path := r.RequestURI
+	// Paths are relative to wherever the book is mounted, so use the (possibly stripped) URL path rather than the
+	// request URI. It is already decoded, and once cleaned cannot climb out of the content root.
+	requested := r.URL.Path
 
-	// In the case this is the root, transform the root into the nav file.
-	if path == "/" {
-		path = "/nav.xhtml"
+	if !strings.HasPrefix(requested, "/") {
+		requested = "/" + requested
 	}
 
-	// Check if the file is in the book
-	exists := false
-	for _, f := range h.EPub.Files() {
-		if fmt.Sprintf("EPUB%s", path) == f {
-			exists = true
+	cleaned := path.Clean(requested)
+	name := strings.TrimPrefix(cleaned, "/")
+
+	// Everything has a single address, so that relative links within documents resolve the same way however they
+	// were reached. Directories end with a slash, and lead to their index document if they have one.
+	canonical := cleaned
+
+	switch {
+	case cleaned == "/" || cleaned == PathSearch || h.Exists(name):
+	case h.directories[name]:
+		canonical = cleaned + "/"
+
+		for _, i := range directoryIndexes {
+			if h.Exists(name + "/" + i) {
+				canonical += i
+				break
+			}
 		}
+	default:
+		problems.Render(w, r, problemNotFound())
+		return
 	}
 
-	// If the file is not th ere, return 404
-	if exists == false {
-		http.Error(w, "Not found", http.StatusNotFound)
+	if canonical != requested {
+		redirect(w, r, canonical)
 		return
 	}
 
-	// Open the file for reading
-	file, err := h.EPub.Open(path)
-	ext := filepath.Ext(path)
+	// Directories without an index document have nothing to serve
+	if h.directories[name] {
+		problems.Render(w, r, problemNotFound())
+		return
+	}
 
-	if err != nil {
-		renderError(err, w)
+	// Books can change underneath the browser at any time, so it should always check its copy is still current.
+	w.Header().Set("Cache-Control", "no-cache")
+
+	// In the case this is the root, render the table of contents.
+	if cleaned == "/" {
+		h.serveDocument(w, r, pageTypeHTML, h.contents)
+		return
+	}
+
+	if cleaned == PathSearch {
+		h.serveSearch(w, r)
 		return
 	}
 
-	w.Header().Set("Content-Type", mime.TypeByExtension(ext))
+	// Documents that have been rendered ahead of time are served straight from memory
+	if d, ok := h.documents[name]; ok {
+		h.serveDocument(w, r, h.types[name], d)
+		return
+	}
 
-	switch ext {
-	case extTypeXHTML:
-		if err := renderHTML(file, w); err != nil {
-			renderError(err, w)
+	// Images may be asked for at a smaller width, for small screens. If there is no such variant, the image is served
+	// as it is; it is what the browser would have had otherwise.
+	if width, err := strconv.Atoi(r.URL.Query().Get(QueryWidth)); err == nil {
+		if d, err := h.variant(name, width); err == nil {
+			h.serveDocument(w, r, h.types[name], d)
+			return
 		}
-	default:
-		if err := renderSimple(file, w); err != nil {
-			renderError(err, w)
+	}
+
+	h.serveFile(w, r, name, h.types[name])
+}
+
+// ServeCover serves the cover of the book as it is in the book, at an address that does not depend on where the book
+// keeps it
+func (h Book) ServeCover(w http.ResponseWriter, r *http.Request) {
+	if h.Cover == nil || !h.Exists(h.Cover.Path) {
+		problems.Render(w, r, problemNotFound())
+		return
+	}
+
+	name := clean(h.Cover.Path)
+
+	// A cover declared to be an SVG image is only served once it has been sanitised, as it may run scripts as any
+	// document can. Trusted books are served as they are, as everywhere else.
+	if baseType(h.Cover.MediaType) == mediaTypeSVG {
+		d, ok := h.documents[name]
+
+		if !ok && h.trust != TrustTrusted {
+			problems.Render(w, r, problemNotFound())
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-cache")
+
+		if ok {
+			h.serveDocument(w, r, mediaTypeSVG, d)
+		} else {
+			h.serveFile(w, r, name, mediaTypeSVG)
 		}
+
+		return
+	}
+
+	// Otherwise, the cover is served as whatever its extension or content says it is, rather than what the book
+	// declares it to be, and only if that is an image that cannot run anything
+	contentType := h.types[name]
+
+	if !raster(contentType) {
+		problems.Render(w, r, problemNotFound())
+		return
 	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	h.serveFile(w, r, name, contentType)
 }
 
-func renderSimple(h io.Reader, w http.ResponseWriter) error {
-	io.Copy(w, h)
+// serveFile serves the file at name, relative to the content root, straight from the archive
+func (h Book) serveFile(w http.ResponseWriter, r *http.Request, name string, contentType string) {
+	f := h.entry(name)
+	content, err := h.seeker(f)
 
-	return nil
+	if err != nil {
+		problems.Render(w, r, err)
+		return
+	}
+
+	// Anything else in an untrusted book could be rendered as a page of the library by the browser, so it can only be
+	// downloaded
+	if len(contentType) == 0 || (h.trust != TrustTrusted && !passive(contentType)) {
+		contentType = mediaTypeDownload
+
+		disposition := mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(name)})
+
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Disposition", disposition)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+
+	// The checksum and size of the file within the archive identify its content without having to read it
+	w.Header().Set("ETag", fmt.Sprintf("\"%08x-%x\"", f.CRC32, f.UncompressedSize64))
+
+	// Handles conditional and range requests
+	http.ServeContent(w, r, name, h.ModTime, content)
 }
 
-func renderHTML(h io.Reader, w http.ResponseWriter) error {
-	xhtmlMobileFriendly := []*html.Node{
-		{Type: html.ElementNode, Data: "meta", Attr: []html.Attribute{
-			{Key: "name", Val: "viewport"},
-			{Key: "content", Val: "width=device-width, initial-scale=1.0"},
-		}},
-		{Type: html.ElementNode, Data: "style", Attr: []html.Attribute{
-			{Key: "type", Val: "text/css"},
-		}, FirstChild: &html.Node{
-			Type: html.TextNode, Data: `
-body {
-	display: block;
-	margin: 0 auto;
-	max-width: 1200px;
-	padding: 0 15px !important;
+// redirect sends the reader to target, relative to wherever the book is mounted, keeping their query
+func redirect(w http.ResponseWriter, r *http.Request, target string) {
+	mount := ""
+
+	// Whatever the book is mounted under has been stripped from the URL path, but not the request URI
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil && strings.HasSuffix(u.Path, r.URL.Path) {
+		mount = strings.TrimSuffix(u.Path, r.URL.Path)
+	}
+
+	u := &url.URL{Path: mount + target, RawQuery: r.URL.RawQuery}
+
+	http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
 }
-`,
-		}},
 
-		// Google Analycs
-		{Type: html.ElementNode, Data: "script", Attr: []html.Attribute{
-			{Key: "async"},
-			{Key: "src", Val: "https://www.googletagmanager.com/gtag/js?id=UA-53227254-5"},
-		}},
-		{Type: html.ElementNode, Data: "script", FirstChild: &html.Node{
-			Type: html.TextNode, Data: `
-			window.dataLayer = window.dataLayer || [];
-			function gtag(){dataLayer.push(arguments);}
-			gtag('js', new Date());
+func (h Book) serveDocument(w http.ResponseWriter, r *http.Request, contentType string, d *Document) {
+	w.Header().Set("Content-Type", contentType)
 
-			gtag('config', 'UA-53227254-5');
-`,
-		}},
+	content := d.Content
+	n := ""
+
+	if d.nonced {
+		n = nonce.FromRequest(r)
+		content = withNonce(content, n)
+	}
+
+	// A document given a nonce is deliberately never cached or revalidated. Its scripts only run under the policy sent
+	// with it, and a copy kept by the browser would be reused under the policy of a later response, with another nonce,
+	// so it is sent whole every time. Range requests are still answered.
+	modified := h.ModTime
+
+	if len(n) > 0 {
+		modified = time.Time{}
+		w.Header().Set("Cache-Control", "no-store")
+	} else {
+		w.Header().Set("ETag", d.ETag)
+	}
+
+	http.ServeContent(w, r, "", modified, bytes.NewReader(content))
+}
+
+// entry returns the file at name, relative to the content root, from the archive
+func (h Book) entry(name string) *zip.File {
+	return h.entries[clean(name)]
+}
+
+// seeker returns the content of a file in a form that can be read from arbitrary offsets. Files stored without
+// compression (which is usually the case for media, as it is compressed already) are read directly from disk;
+// anything else must first be decompressed into memory.
+func (h Book) seeker(f *zip.File) (io.ReadSeeker, error) {
+	if f.Method == zip.Store {
+		offset, err := f.DataOffset()
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read %s", f.Name)
+		}
+
+		return io.NewSectionReader(h.file, offset, int64(f.UncompressedSize64)), nil
+	}
+
+	r, err := f.Open()
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read %s", f.Name)
+	}
+
+	defer r.Close()
+
+	content, err := ioutil.ReadAll(r)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read %s", f.Name)
+	}
+
+	return bytes.NewReader(content), nil
+}
+
+// renderHTML adds the library's markup to the document at path and writes it out
+func (h Book) renderHTML(path string, doc *html.Node, w io.Writer) error {
+	head, err := h.injection.head(h.API(), path)
+
+	if err != nil {
+		return err
+	}
+
+	body, err := h.injection.body()
+
+	if err != nil {
+		return err
+	}
+
+	// The library's scripts must run whatever policy the document is served under, as must the book's own if it is
+	// trusted to run them
+	addNonce(head...)
+	addNonce(body...)
+
+	if h.trust == TrustTrusted {
+		addNonce(doc)
 	}
 
 	// Function to traverse the HTML tree
-	// Todo: This should be pulled out, and the pages modified before storing in memory for later access.
 	var f func(*html.Node)
 	f = func(n *html.Node) {
 		if n.Type == html.ElementNode && n.Data == "head" {
-			for _, x := range xhtmlMobileFriendly {
+			for _, x := range head {
+				n.AppendChild(x)
+			}
+		}
+
+		if n.Type == html.ElementNode && n.Data == "body" {
+			if p := h.pagination(path); p != nil {
+				n.AppendChild(p)
+			}
+
+			for _, x := range body {
 				n.AppendChild(x)
 			}
 		}
@@ -124,21 +312,72 @@ body {
 		}
 	}
 
-	doc, err := html.Parse(h)
+	// Add the library's markup to the document
+	f(doc)
 
-	if err != nil {
-		return errors.Wrap(err, "unable to parse html")
+	return errors.Wrap(html.Render(w, doc), "unable to render html")
+}
+
+// pagination returns links to the documents either side of path in the reading order, as well as to the table of
+// contents. If path is not in the reading order, there is nothing to link and nil is returned.
+func (h Book) pagination(path string) *html.Node {
+	linear := []SpineItem{}
+	current := -1
+
+	for _, i := range h.Spine {
+		if !i.Linear {
+			continue
+		}
+
+		if i.Path == path {
+			current = len(linear)
+		}
+
+		linear = append(linear, i)
 	}
 
-	// Modify DOc
-	f(doc)
-	html.Render(w, doc)
+	if current < 0 {
+		return nil
+	}
 
-	return nil
-}
+	nav := &html.Node{Type: html.ElementNode, Data: "nav", Attr: []html.Attribute{
+		{Key: "class", Val: "library-pagination"},
+	}}
+
+	link := func(rel string, label string, target *url.URL, title string) {
+		a := &html.Node{Type: html.ElementNode, Data: "a", Attr: []html.Attribute{
+			{Key: "rel", Val: rel},
+			{Key: "href", Val: relative(path, target)},
+		}}
+
+		if len(title) > 0 {
+			a.Attr = append(a.Attr, html.Attribute{Key: "title", Val: title})
+		}
+
+		a.AppendChild(&html.Node{Type: html.TextNode, Data: label})
+		nav.AppendChild(a)
+	}
+
+	// Empty spans keep the remaining links in place when there is no previous or next document
+	spacer := func() {
+		nav.AppendChild(&html.Node{Type: html.ElementNode, Data: "span"})
+	}
+
+	if current > 0 {
+		prev := linear[current-1]
+		link("prev", "← Previous", &url.URL{Path: prev.Path}, h.titles[prev.Path])
+	} else {
+		spacer()
+	}
+
+	link("contents", "Contents", &url.URL{}, "")
+
+	if current < len(linear)-1 {
+		next := linear[current+1]
+		link("next", "Next →", &url.URL{Path: next.Path}, h.titles[next.Path])
+	} else {
+		spacer()
+	}
 
-func renderError(e error, w http.ResponseWriter) {
-	// Todo: This should be a better error handler, including logging errors
-	w.WriteHeader(http.StatusInternalServerError)
-	w.Write([]byte(e.Error()))
+	return nav
 }
@@ -0,0 +1,183 @@
+package book
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBookHandler(t *testing.T) {
+	b := testBook(t, map[string]string{
+		"text/chapter.xhtml":    testDocument(`<p>Chapter</p>`),
+		"text/part/index.xhtml": testDocument(`<p>Part</p>`),
+		"images/cover.png":      "\x89PNG\r\n\x1a\n",
+	})
+
+	tests := []struct {
+		name     string
+		target   string
+		status   int
+		location string
+	}{
+		{name: "contents", target: "/", status: http.StatusOK},
+		{name: "document", target: "/text/chapter.xhtml", status: http.StatusOK},
+		{name: "file", target: "/images/cover.png", status: http.StatusOK},
+		{name: "missing", target: "/text/missing.xhtml", status: http.StatusNotFound},
+		{
+			name:     "parent segments",
+			target:   "/text/part/../chapter.xhtml",
+			status:   http.StatusMovedPermanently,
+			location: "/text/chapter.xhtml",
+		},
+		{
+			name:     "encoded parent segments",
+			target:   "/text/%2e%2e/text/chapter.xhtml",
+			status:   http.StatusMovedPermanently,
+			location: "/text/chapter.xhtml",
+		},
+		{name: "parent segments above the root", target: "/../../etc/passwd", status: http.StatusNotFound},
+		{
+			name:     "parent segments above the root to a file",
+			target:   "/../../text/chapter.xhtml",
+			status:   http.StatusMovedPermanently,
+			location: "/text/chapter.xhtml",
+		},
+		{
+			name:     "duplicate slashes",
+			target:   "/text//chapter.xhtml",
+			status:   http.StatusMovedPermanently,
+			location: "/text/chapter.xhtml",
+		},
+		{
+			name:     "trailing slash on a file",
+			target:   "/text/chapter.xhtml/",
+			status:   http.StatusMovedPermanently,
+			location: "/text/chapter.xhtml",
+		},
+		{
+			name:     "directory",
+			target:   "/text/part",
+			status:   http.StatusMovedPermanently,
+			location: "/text/part/index.xhtml",
+		},
+		{
+			name:     "directory with a slash",
+			target:   "/text/part/",
+			status:   http.StatusMovedPermanently,
+			location: "/text/part/index.xhtml",
+		},
+		{name: "directory without an index", target: "/images", status: http.StatusMovedPermanently, location: "/images/"},
+		{name: "directory without an index with a slash", target: "/images/", status: http.StatusNotFound},
+		{
+			name:     "query",
+			target:   "/text//chapter.xhtml?a=1&b=two%20words",
+			status:   http.StatusMovedPermanently,
+			location: "/text/chapter.xhtml?a=1&b=two%20words",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			b.Handler(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+		})
+	}
+}
+
+func TestBookHandlerMounted(t *testing.T) {
+	b := testBook(t, map[string]string{
+		"text/chapter.xhtml":    testDocument(`<p>Chapter</p>`),
+		"text/part/index.xhtml": testDocument(`<p>Part</p>`),
+	})
+
+	handler := http.StripPrefix("/books/test", http.HandlerFunc(b.Handler))
+
+	tests := []struct {
+		target   string
+		location string
+	}{
+		{target: "/books/test/text/../text/chapter.xhtml", location: "/books/test/text/chapter.xhtml"},
+		{target: "/books/test/text//chapter.xhtml?a=1", location: "/books/test/text/chapter.xhtml?a=1"},
+		{target: "/books/test/text/part", location: "/books/test/text/part/index.xhtml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if w.Code != http.StatusMovedPermanently {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusMovedPermanently)
+			}
+
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+		})
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	tests := []struct {
+		name       string
+		requestURI string
+		path       string
+		target     string
+		want       string
+	}{
+		{
+			name:       "not mounted",
+			requestURI: "/text//chapter.xhtml",
+			path:       "/text//chapter.xhtml",
+			target:     "/text/chapter.xhtml",
+			want:       "/text/chapter.xhtml",
+		},
+		{
+			name:       "mounted",
+			requestURI: "/books/test/text//chapter.xhtml",
+			path:       "/text//chapter.xhtml",
+			target:     "/text/chapter.xhtml",
+			want:       "/books/test/text/chapter.xhtml",
+		},
+		{
+			name:       "mounted with a query",
+			requestURI: "/books/test/text//chapter.xhtml?q=a%26b",
+			path:       "/text//chapter.xhtml",
+			target:     "/text/chapter.xhtml",
+			want:       "/books/test/text/chapter.xhtml?q=a%26b",
+		},
+		{
+			name:       "path that is not the end of the request URI",
+			requestURI: "/books/test/other",
+			path:       "/text//chapter.xhtml",
+			target:     "/text/chapter.xhtml",
+			want:       "/text/chapter.xhtml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.requestURI, nil)
+			r.URL.Path = tt.path
+
+			w := httptest.NewRecorder()
+			redirect(w, r, tt.target)
+
+			if w.Code != http.StatusMovedPermanently {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusMovedPermanently)
+			}
+
+			if got := w.Header().Get("Location"); got != tt.want {
+				t.Errorf("Location = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
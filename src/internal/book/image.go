@@ -0,0 +1,364 @@
+package book
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+const (
+	// QueryWidth asks for an image in the book scaled down to the width it names, which must be one of the widths
+	// the book makes variants of its images at. Anything else is answered with the image as it is.
+	QueryWidth = "width"
+
+	// imageMaxPixels is the most pixels an image may have to be scaled, so that one that is small on disk cannot take
+	// all the memory there is once decoded
+	imageMaxPixels = 64 * 1024 * 1024
+
+	// imageMaxWidth is the widest variant of an image that can be asked for
+	imageMaxWidth = 4096
+
+	imageQuality = 85
+
+	// variantsMaxBytes is how much memory the variants of the images of a book may take. Beyond it, they are made
+	// again as they are asked for.
+	variantsMaxBytes = 32 * 1024 * 1024
+
+	// imageMaxDecodes is how many images may be decoded and scaled at once across every book, so that the memory it
+	// takes is bounded however many are asked for
+	imageMaxDecodes = 2
+)
+
+// decodes is held for each image being decoded and scaled, up to imageMaxDecodes
+var decodes = make(chan struct{}, imageMaxDecodes)
+
+// DefaultImageWidths are the widths variants of images are made at when none are configured; those of common phone,
+// tablet and laptop screens.
+var DefaultImageWidths = []int{320, 640, 960, 1280}
+
+// variants keeps the images of a book that have been scaled down, by path and width. Each is made while holding the
+// lock for its key, so that the others can be served meanwhile.
+type variants struct {
+	keys keyLocks
+
+	mu   sync.Mutex
+	kept map[string]*Document
+	size int
+}
+
+// WithImageWidths sets the widths smaller variants of the images in the book are made at, for browsers to choose from.
+// With none, images are only loaded lazily and given their size.
+func WithImageWidths(widths ...int) func(*Book) error {
+	return func(h *Book) error {
+		for _, w := range widths {
+			if w < 1 || w > imageMaxWidth {
+				return errors.Errorf("invalid image width %d, must be between 1 and %d", w, imageMaxWidth)
+			}
+		}
+
+		h.imageWidths = append([]int{}, widths...)
+		sort.Ints(h.imageWidths)
+
+		return nil
+	}
+}
+
+// scalable indicates whether variants can be made of the file at name. Animated and vector images are left as they
+// are.
+func scalable(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg", ".png":
+		return true
+	}
+
+	return false
+}
+
+// responsive makes every image in doc, the document at name, load only once it is about to be seen, take up its space
+// before it has so that the text around it does not move, and offer smaller variants of itself to small screens.
+func (h *Book) responsive(name string, doc *html.Node) {
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "img" && len(n.Namespace) == 0 {
+			h.responsiveImage(name, n)
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+
+	f(doc)
+}
+
+// responsiveImage rewrites the img element n, in the document at name
+func (h *Book) responsiveImage(name string, n *html.Node) {
+	set := func(key string, val string) {
+		for _, a := range n.Attr {
+			if a.Key == key && len(a.Namespace) == 0 {
+				return
+			}
+		}
+
+		n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+	}
+
+	set("loading", "lazy")
+
+	u, err := resolve(name, attr(n, "src"))
+
+	if err != nil || len(u.Scheme) > 0 || len(u.Host) > 0 || !h.Exists(u.Path) {
+		return
+	}
+
+	config, ok := h.dimensions(u.Path)
+
+	if !ok {
+		return
+	}
+
+	// Either dimension on its own is enough for the other to follow the image
+	if len(attr(n, "width")) == 0 && len(attr(n, "height")) == 0 {
+		set("width", strconv.Itoa(config.Width))
+		set("height", strconv.Itoa(config.Height))
+	}
+
+	// Images that choose between variants of their own, or are within a picture element that does, are left to
+	if !scalable(u.Path) || len(u.RawQuery) > 0 || (n.Parent != nil && n.Parent.Data == "picture") {
+		return
+	}
+
+	ref := relative(name, &url.URL{Path: u.Path})
+	candidates := []string{}
+
+	for _, w := range h.imageWidths {
+		if w < config.Width {
+			candidates = append(candidates, ref+"?"+QueryWidth+"="+strconv.Itoa(w)+" "+strconv.Itoa(w)+"w")
+		}
+	}
+
+	if len(candidates) == 0 {
+		return
+	}
+
+	set("srcset", strings.Join(append(candidates, ref+" "+strconv.Itoa(config.Width)+"w"), ", "))
+}
+
+// dimensions returns the size of the image at name, reading only as much of it as it takes to find out
+func (h *Book) dimensions(name string) (image.Config, bool) {
+	if config, ok := h.images[name]; ok {
+		return config, true
+	}
+
+	f, err := h.Open(name)
+
+	if err != nil {
+		return image.Config{}, false
+	}
+
+	defer f.Close()
+
+	config, _, err := image.DecodeConfig(f)
+
+	if err != nil {
+		return image.Config{}, false
+	}
+
+	h.images[name] = config
+
+	return config, true
+}
+
+// variant returns the image at name scaled down to width, making it if it has not been made already. Only the widths
+// the book is configured with can be made, and only for images smaller than them that are in its documents.
+func (h Book) variant(name string, width int) (*Document, error) {
+	config, ok := h.images[name]
+
+	if !ok || !scalable(name) || width >= config.Width || !h.hasImageWidth(width) {
+		return nil, errors.Errorf("unable to scale %s: no variant %d wide", name, width)
+	}
+
+	key := name + "?" + strconv.Itoa(width)
+
+	unlock := h.variants.keys.lock(key)
+	defer unlock()
+
+	h.variants.mu.Lock()
+	d, ok := h.variants.kept[key]
+	h.variants.mu.Unlock()
+
+	// Variants that turned out no smaller than the image are kept as nothing, so they are not made again
+	if ok {
+		if d == nil {
+			return nil, errors.Errorf("unable to scale %s: variant %d wide is no smaller", name, width)
+		}
+
+		return d, nil
+	}
+
+	content, err := h.scaleImage(name, width)
+
+	if err != nil {
+		return nil, err
+	}
+
+	h.variants.mu.Lock()
+	defer h.variants.mu.Unlock()
+
+	// Diagrams with few colours can be smaller as they are than scaled, once every pixel is a blend of several
+	if f := h.entry(name); f != nil && uint64(len(content)) >= f.UncompressedSize64 {
+		h.variants.kept[key] = nil
+
+		return nil, errors.Errorf("unable to scale %s: variant %d wide is no smaller", name, width)
+	}
+
+	d = &Document{Content: content, ETag: etag(content)}
+
+	if h.variants.size+len(d.Content) > variantsMaxBytes {
+		h.variants.kept = map[string]*Document{}
+		h.variants.size = 0
+	}
+
+	h.variants.kept[key] = d
+	h.variants.size += len(d.Content)
+
+	return d, nil
+}
+
+// scaleImage returns the image at name scaled down to width, encoded as it was
+func (h Book) scaleImage(name string, width int) ([]byte, error) {
+	decodes <- struct{}{}
+	defer func() { <-decodes }()
+
+	img, format, err := h.decode(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	b := img.Bounds()
+	scaled := scale(img, width, max(1, (b.Dy()*width+b.Dx()/2)/b.Dx()))
+	buf := &bytes.Buffer{}
+
+	if format == "jpeg" {
+		err = jpeg.Encode(buf, scaled, &jpeg.Options{Quality: imageQuality})
+	} else {
+		err = png.Encode(buf, scaled)
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to scale %s", name)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// hasImageWidth indicates whether variants of images are made at width
+func (h Book) hasImageWidth(width int) bool {
+	for _, w := range h.imageWidths {
+		if w == width {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decode reads the image at name, refusing any so large that decoding it would take all the memory there is
+func (h Book) decode(name string) (image.Image, string, error) {
+	f, err := h.Open(name)
+
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "unable to decode %s", name)
+	}
+
+	config, _, err := image.DecodeConfig(f)
+	f.Close()
+
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "unable to decode %s", name)
+	}
+
+	if config.Width*config.Height > imageMaxPixels {
+		return nil, "", errors.Errorf("unable to decode %s: %dx%d is too large", name, config.Width, config.Height)
+	}
+
+	if f, err = h.Open(name); err != nil {
+		return nil, "", errors.Wrapf(err, "unable to decode %s", name)
+	}
+
+	defer f.Close()
+
+	img, format, err := image.Decode(f)
+
+	return img, format, errors.Wrapf(err, "unable to decode %s", name)
+}
+
+// scale returns img scaled to width by height, each pixel the average of every pixel it covers so that fine detail,
+// such as the lines of a diagram or the text of a title, blurs rather than breaks up. Images are only ever scaled down;
+// an image that is already that size is returned as it is.
+func scale(img image.Image, width int, height int) *image.RGBA {
+	bounds := img.Bounds()
+
+	// Pixels are premultiplied by their opacity, so transparent ones count for nothing in the average
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	sw, sh := bounds.Dx(), bounds.Dy()
+
+	if width >= sw && height >= sh {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for dy := 0; dy < height; dy++ {
+		y0, y1 := dy*sh/height, max((dy+1)*sh/height, dy*sh/height+1)
+
+		for dx := 0; dx < width; dx++ {
+			x0, x1 := dx*sw/width, max((dx+1)*sw/width, dx*sw/width+1)
+
+			var sum [4]int
+			n := 0
+
+			for y := y0; y < y1; y++ {
+				row := src.Pix[y*src.Stride:]
+
+				for x := x0; x < x1; x++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(row[x*4+c])
+					}
+
+					n++
+				}
+			}
+
+			i := dy*dst.Stride + dx*4
+
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+
+	return dst
+}
+
+func max(a int, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
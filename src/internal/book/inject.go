@@ -0,0 +1,1171 @@
+package book
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	// attributeAPI is the attribute of each script the library injects that declares where the API for the book is.
+	// Scripts are told about the page on themselves rather than anywhere in it, where a book could have said otherwise.
+	attributeAPI = "data-library-api"
+
+	// attributeDocument is the attribute of each script the library injects that declares which document of the book
+	// the page is, relative to the content root. It is empty for pages generated by the library.
+	attributeDocument = "data-library-document"
+
+	// InjectTemplateHead is the file in a templates directory whose content is added to the head of every document
+	InjectTemplateHead = "head.html"
+
+	// InjectTemplateBody is the file in a templates directory whose content is added to the body of every document
+	InjectTemplateBody = "body.html"
+
+	// InjectTemplateStylesheet is the file in a templates directory that is added as a stylesheet to every document
+	InjectTemplateStylesheet = "style.css"
+)
+
+// injectDefaults makes books readable on small screens, and is what has historically been injected.
+const injectDefaults = `
+<meta name="viewport" content="width=device-width, initial-scale=1.0"/>
+<style type="text/css">
+body {
+	display: block;
+	margin: 0 auto;
+	max-width: 1200px;
+	padding: 0 15px !important;
+}
+</style>
+`
+
+// injectLibrary styles the elements the library itself adds to documents, highlights the terms that led the reader to
+// it from a search and offers a link to each passage. It is always injected.
+//
+// Documents may be parsed as XML, so the script must not contain "<" or "&".
+const injectLibrary = `
+<style type="text/css">
+.library-pagination {
+	display: flex;
+	justify-content: space-between;
+	margin: 2em 0;
+}
+
+.library-anchor {
+	color: #888;
+	font-size: 0.8em;
+	margin-left: 0.25em;
+	opacity: 0;
+	text-decoration: none;
+}
+
+:hover > .library-anchor, .library-anchor:focus {
+	opacity: 1;
+}
+
+.library-anchor[data-copied]::after {
+	content: " Link copied";
+}
+
+[id^="` + AnchorPrefix + `"]:target {
+	background: #fff8c5;
+}
+</style>
+<script>
+document.addEventListener("DOMContentLoaded", function () {
+	var query = new URLSearchParams(window.location.search).get("` + QueryHighlight + `");
+
+	if (!query) {
+		return;
+	}
+
+	var terms = query.split(/[^\p{L}\p{N}]+/u).filter(Boolean).map(function (t) {
+		return t.replace(/[.*+?^${}()|[\]\\]/g, function (c) {
+			return "\\" + c;
+		});
+	});
+
+	if (terms.length === 0) {
+		return;
+	}
+
+	var pattern = new RegExp("(" + terms.join("|") + ")", "giu");
+	var walker = document.createTreeWalker(document.body, NodeFilter.SHOW_TEXT);
+	var nodes = [];
+
+	while (walker.nextNode()) {
+		if (!walker.currentNode.parentNode.closest("script, style, nav, .library-ui")) {
+			nodes.push(walker.currentNode);
+		}
+	}
+
+	var first = null;
+
+	nodes.forEach(function (node) {
+		var parts = node.data.split(pattern);
+
+		if (parts.length === 1) {
+			return;
+		}
+
+		var fragment = document.createDocumentFragment();
+
+		parts.forEach(function (part, i) {
+			if (i % 2 === 0) {
+				fragment.appendChild(document.createTextNode(part));
+				return;
+			}
+
+			var mark = document.createElement("mark");
+			mark.textContent = part;
+			fragment.appendChild(mark);
+			first = first || mark;
+		});
+
+		node.parentNode.replaceChild(fragment, node);
+	});
+
+	if (first) {
+		first.scrollIntoView({block: "center"});
+	}
+});
+
+// Every passage with an id can be linked to, and offers to copy the link
+document.addEventListener("DOMContentLoaded", function () {
+	var passages = "p, h1, h2, h3, h4, h5, h6, li, dt, dd, blockquote, pre, figcaption";
+
+	document.body.querySelectorAll("[id]").forEach(function (element) {
+		if (!element.matches(passages) || element.closest("nav, .library-ui")) {
+			return;
+		}
+
+		var link = document.createElement("a");
+
+		link.className = "library-ui library-anchor";
+		link.href = "#" + encodeURIComponent(element.id);
+		link.title = "Copy a link to this passage";
+		link.textContent = "¶";
+
+		link.addEventListener("click", function (e) {
+			e.preventDefault();
+
+			var target = window.location.href.split(/[?#]/)[0] + "#" + encodeURIComponent(element.id);
+
+			window.history.replaceState(null, "", target);
+
+			if (navigator.clipboard) {
+				navigator.clipboard.writeText(target).then(function () {
+					link.dataset.copied = "";
+
+					setTimeout(function () {
+						delete link.dataset.copied;
+					}, 2000);
+				});
+			}
+		});
+
+		element.appendChild(link);
+	});
+});
+</script>
+`
+
+// injectProgress records how far through the book the reader is, and returns them to where they were. It is only
+// injected if the book has an API.
+//
+// Documents may be parsed as XML, so the script must not contain "<" or "&".
+const injectProgress = `
+<script>
+(function (script) {
+	document.addEventListener("DOMContentLoaded", function () {
+		var api = script.getAttribute("` + attributeAPI + `");
+		var path = script.getAttribute("` + attributeDocument + `");
+
+		if (!api || path === null) {
+			return;
+		}
+
+		var url = api + "/progress";
+		var options = {credentials: "same-origin", headers: {"Accept": "application/json"}};
+
+		// The table of contents offers to continue from wherever the reader was
+		var resume = document.querySelector(".library-continue");
+
+		if (!path) {
+			if (!resume) {
+				return;
+			}
+
+			fetch(url, options).then(function (response) {
+				return response.ok ? response.json() : null;
+			}).then(function (progress) {
+				if (progress) {
+					resume.querySelector("a").href = encodeURI(progress.path);
+					resume.hidden = false;
+				}
+			});
+
+			return;
+		}
+
+		function scrollable() {
+			return Math.max(document.documentElement.scrollHeight - window.innerHeight, 0);
+		}
+
+		function save() {
+			var position = scrollable() ? Math.min(window.scrollY / scrollable(), 1) : 0;
+
+			fetch(url, {
+				method: "PUT",
+				credentials: "same-origin",
+				keepalive: true,
+				headers: {"Content-Type": "application/json"},
+				body: JSON.stringify({path: path, position: position})
+			});
+		}
+
+		fetch(url, options).then(function (response) {
+			// Not found means the book has not been started; anything else means progress cannot be recorded.
+			if (!response.ok) {
+				return response.status === 404 ? {} : null;
+			}
+
+			return response.json();
+		}).then(function (progress) {
+			if (!progress) {
+				return;
+			}
+
+			// Links to a particular place in the document take priority over where the reader was
+			var linked = window.location.hash || new URLSearchParams(window.location.search).has("` + QueryHighlight + `");
+
+			if (progress.path === path) {
+				if (!linked) {
+					window.scrollTo(0, progress.position * scrollable());
+				}
+			}
+
+			var timer = null;
+
+			window.addEventListener("scroll", function () {
+				clearTimeout(timer);
+				timer = setTimeout(save, 1000);
+			});
+
+			save();
+		});
+	});
+})(document.currentScript);
+</script>
+`
+
+// injectAnnotations draws the highlights, notes and bookmarks the reader has made in a document, and lets them make
+// more by selecting text. It is only injected if the book has an API.
+//
+// The script is complex enough to need comparisons, so it is wrapped in CDATA for documents parsed as XML.
+const injectAnnotations = `
+<style type="text/css">
+mark.library-annotation {
+	background: #fff3a3;
+	color: inherit;
+	cursor: pointer;
+}
+
+mark.library-annotation[title] {
+	border-bottom: 2px dotted #b08800;
+}
+
+.library-toolbar {
+	background: #fff;
+	border: 1px solid #ccc;
+	border-radius: 4px;
+	box-shadow: 0 2px 6px rgba(0, 0, 0, 0.2);
+	font: 14px sans-serif;
+	padding: 4px;
+	position: fixed;
+	right: 1em;
+	bottom: 1em;
+	z-index: 1000;
+}
+
+.library-toolbar button {
+	margin: 0 2px;
+}
+</style>
+<script>
+//<![CDATA[
+(function (script) {
+	document.addEventListener("DOMContentLoaded", function () {
+		var api = script.getAttribute("` + attributeAPI + `");
+		var source = script.getAttribute("` + attributeDocument + `");
+
+		if (!api || !source) {
+			return;
+		}
+
+		var url = api + "/annotations";
+		var context = 32;
+
+		// The text of the document, as the offsets of selectors count it
+		function texts() {
+			var walker = document.createTreeWalker(document.body, NodeFilter.SHOW_TEXT);
+			var nodes = [];
+			var offset = 0;
+
+			while (walker.nextNode()) {
+				var node = walker.currentNode;
+
+				if (node.parentNode.closest("script, style, .library-pagination, .library-ui")) {
+					continue;
+				}
+
+				nodes.push({node: node, start: offset});
+				offset += node.data.length;
+			}
+
+			return {nodes: nodes, text: nodes.map(function (n) { return n.node.data; }).join("")};
+		}
+
+		function offset(index, container, within) {
+			for (var i = 0; i < index.nodes.length; i++) {
+				if (index.nodes[i].node === container) {
+					return index.nodes[i].start + within;
+				}
+			}
+
+			return -1;
+		}
+
+		// extent returns where the text of an element starts and ends
+		function extent(index, element) {
+			if (!element) {
+				return null;
+			}
+
+			var inside = index.nodes.filter(function (n) {
+				return element.contains(n.node);
+			});
+
+			if (!inside.length) {
+				return null;
+			}
+
+			var last = inside[inside.length - 1];
+
+			return {start: inside[0].start, end: last.start + last.node.data.length};
+		}
+
+		// locate finds where an annotation is, preferring its quote and falling back to its position
+		function locate(index, annotation) {
+			var quote = null;
+			var position = null;
+			var within = null;
+
+			(annotation.target.selector || []).forEach(function (s) {
+				if (s.type === "TextQuoteSelector") {
+					quote = s;
+				} else if (s.type === "TextPositionSelector") {
+					position = s;
+				} else if (s.type === "FragmentSelector") {
+					within = extent(index, document.getElementById(s.value));
+				}
+			});
+
+			if (quote) {
+				var best = null;
+				var bestScore = -1;
+
+				for (var at = index.text.indexOf(quote.exact); at !== -1; at = index.text.indexOf(quote.exact, at + 1)) {
+					var score = 0;
+					var before = index.text.slice(Math.max(at - context, 0), at);
+					var after = index.text.slice(at + quote.exact.length, at + quote.exact.length + context);
+
+					if (quote.prefix && before.endsWith(quote.prefix)) {
+						score += 2;
+					}
+
+					if (quote.suffix && after.startsWith(quote.suffix)) {
+						score += 2;
+					}
+
+					if (position && at === position.start) {
+						score += 1;
+					}
+
+					// The passage the text was selected in is the strongest hint of all, as its id does not change
+					if (within && at >= within.start && at + quote.exact.length <= within.end) {
+						score += 5;
+					}
+
+					if (score > bestScore) {
+						best = at;
+						bestScore = score;
+					}
+				}
+
+				if (best !== null) {
+					return {start: best, end: best + quote.exact.length};
+				}
+			}
+
+			if (position && position.end <= index.text.length) {
+				return {start: position.start, end: position.end};
+			}
+
+			return null;
+		}
+
+		// draw wraps the text of an annotation in marks, one for each text node it spans
+		function draw(annotation) {
+			var index = texts();
+			var range = locate(index, annotation);
+
+			if (!range) {
+				return;
+			}
+
+			index.nodes.forEach(function (n) {
+				var start = Math.max(range.start - n.start, 0);
+				var end = Math.min(range.end - n.start, n.node.data.length);
+
+				if (start >= end) {
+					return;
+				}
+
+				var node = n.node;
+
+				if (end < node.data.length) {
+					node.splitText(end);
+				}
+
+				if (start > 0) {
+					node = node.splitText(start);
+				}
+
+				var mark = document.createElement("mark");
+				mark.className = "library-annotation";
+				mark.dataset.id = annotation.id;
+
+				if (annotation.bodyValue) {
+					mark.title = annotation.bodyValue;
+				}
+
+				node.parentNode.replaceChild(mark, node);
+				mark.appendChild(node);
+			});
+		}
+
+		function erase(id) {
+			document.querySelectorAll("mark.library-annotation[data-id='" + id + "']").forEach(function (mark) {
+				var parent = mark.parentNode;
+
+				while (mark.firstChild) {
+					parent.insertBefore(mark.firstChild, mark);
+				}
+
+				parent.removeChild(mark);
+				parent.normalize();
+			});
+		}
+
+		function create(annotation) {
+			annotation.target.source = source;
+
+			return fetch(url, {
+				method: "POST",
+				credentials: "same-origin",
+				headers: {"Accept": "application/json", "Content-Type": "application/json"},
+				body: JSON.stringify(annotation)
+			}).then(function (response) {
+				return response.ok ? response.json() : null;
+			});
+		}
+
+		// selected describes the text the reader has selected, if it is within the document
+		function selected() {
+			var selection = window.getSelection();
+
+			if (!selection.rangeCount || selection.isCollapsed) {
+				return null;
+			}
+
+			var range = selection.getRangeAt(0);
+			var index = texts();
+			var start = offset(index, range.startContainer, range.startOffset);
+			var end = offset(index, range.endContainer, range.endOffset);
+
+			if (start === -1 || end === -1 || end <= start) {
+				return null;
+			}
+
+			var selector = [{
+				type: "TextQuoteSelector",
+				exact: index.text.slice(start, end),
+				prefix: index.text.slice(Math.max(start - context, 0), start),
+				suffix: index.text.slice(end, end + context)
+			}, {
+				type: "TextPositionSelector",
+				start: start,
+				end: end
+			}];
+
+			// Passages have ids, so the selection can be tied to the one it starts in
+			var container = range.startContainer.parentNode.closest("[id]");
+
+			if (container) {
+				selector.push({type: "FragmentSelector", value: container.id});
+			}
+
+			return selector;
+		}
+
+		fetch(url + "?source=" + encodeURIComponent(source), {
+			credentials: "same-origin",
+			headers: {"Accept": "application/json"}
+		}).then(function (response) {
+			return response.ok ? response.json() : null;
+		}).then(function (annotations) {
+			// Without annotations, the reader is not signed in or there is nowhere to keep them
+			if (!annotations) {
+				return;
+			}
+
+			var bookmark = null;
+
+			annotations.forEach(function (a) {
+				if (a.motivation === "bookmarking") {
+					bookmark = a;
+				} else {
+					draw(a);
+				}
+			});
+
+			var ui = document.createElement("div");
+			ui.className = "library-ui library-toolbar";
+
+			function button(label, onclick) {
+				var b = document.createElement("button");
+				b.type = "button";
+				b.textContent = label;
+				b.addEventListener("mousedown", function (e) {
+					// Keep the selection the button acts on
+					e.preventDefault();
+				});
+				b.addEventListener("click", onclick);
+				ui.appendChild(b);
+
+				return b;
+			}
+
+			function annotate(motivation) {
+				return function () {
+					var selector = selected();
+
+					if (!selector) {
+						return;
+					}
+
+					var annotation = {motivation: motivation, target: {selector: selector}};
+
+					if (motivation === "commenting") {
+						annotation.bodyValue = window.prompt("Note");
+
+						if (!annotation.bodyValue) {
+							return;
+						}
+					}
+
+					create(annotation).then(function (a) {
+						if (a) {
+							window.getSelection().removeAllRanges();
+							draw(a);
+						}
+					});
+				};
+			}
+
+			button("Highlight", annotate("highlighting"));
+			button("Note", annotate("commenting"));
+
+			var mark = button(bookmark ? "Remove bookmark" : "Bookmark", function () {
+				if (bookmark) {
+					fetch(url + "/" + encodeURIComponent(bookmark.id), {method: "DELETE", credentials: "same-origin"})
+						.then(function (response) {
+							if (response.ok) {
+								bookmark = null;
+								mark.textContent = "Bookmark";
+							}
+						});
+
+					return;
+				}
+
+				create({motivation: "bookmarking", target: {}}).then(function (a) {
+					if (a) {
+						bookmark = a;
+						mark.textContent = "Remove bookmark";
+					}
+				});
+			});
+
+			document.body.appendChild(ui);
+
+			document.body.addEventListener("click", function (e) {
+				var target = e.target.closest("mark.library-annotation");
+
+				if (!target || !window.getSelection().isCollapsed) {
+					return;
+				}
+
+				var message = target.title ? "Delete this note?\n\n" + target.title : "Delete this highlight?";
+
+				if (!window.confirm(message)) {
+					return;
+				}
+
+				fetch(url + "/" + encodeURIComponent(target.dataset.id), {method: "DELETE", credentials: "same-origin"})
+					.then(function (response) {
+						if (response.ok) {
+							erase(target.dataset.id);
+						}
+					});
+			});
+		});
+	});
+})(document.currentScript);
+//]]>
+</script>
+`
+
+// injectThreads shows the reviewers' comment threads beside the paragraphs they are about, and lets reviewers start,
+// reply to, resolve and reopen them. It is only injected if the book has an API.
+//
+// The script is complex enough to need comparisons, so it is wrapped in CDATA for documents parsed as XML.
+const injectThreads = `
+<style type="text/css">
+.library-thread-toggle {
+	background: none;
+	border: none;
+	color: #888;
+	cursor: pointer;
+	float: right;
+	font: 12px sans-serif;
+	opacity: 0.3;
+}
+
+.library-thread-toggle:hover, .library-thread-toggle[data-open] {
+	opacity: 1;
+}
+
+.library-threads {
+	border-left: 3px solid #b08800;
+	font: 14px sans-serif;
+	margin: 0.5em 0 1em;
+	padding: 0 0.75em;
+}
+
+.library-thread {
+	margin: 0.5em 0;
+}
+
+.library-thread[data-resolved] .library-comment {
+	color: #888;
+}
+
+.library-comment {
+	margin: 0.25em 0;
+	white-space: pre-wrap;
+}
+
+.library-threads textarea {
+	box-sizing: border-box;
+	display: block;
+	width: 100%;
+}
+</style>
+<script>
+//<![CDATA[
+(function (script) {
+	document.addEventListener("DOMContentLoaded", function () {
+		var api = script.getAttribute("` + attributeAPI + `");
+		var source = script.getAttribute("` + attributeDocument + `");
+
+		if (!api || !source) {
+			return;
+		}
+
+		var url = api + "/threads";
+		var quoteLength = 160;
+
+		// The text of a paragraph, ignoring anything the library has added to it
+		function text(element) {
+			var walker = document.createTreeWalker(element, NodeFilter.SHOW_TEXT);
+			var parts = [];
+
+			while (walker.nextNode()) {
+				if (!walker.currentNode.parentNode.closest(".library-ui")) {
+					parts.push(walker.currentNode.data);
+				}
+			}
+
+			return parts.join("").replace(/\s+/g, " ").trim();
+		}
+
+		function paragraphs() {
+			return Array.prototype.filter.call(document.body.querySelectorAll("p"), function (p) {
+				return !p.closest(".library-ui, .library-pagination") && text(p);
+			});
+		}
+
+		// locate finds the paragraph a thread is about, preferring its ID and falling back to its text
+		function locate(thread) {
+			var selectors = thread.target.selector || [];
+
+			for (var i = 0; i < selectors.length; i++) {
+				if (selectors[i].type === "FragmentSelector") {
+					var element = document.getElementById(selectors[i].value);
+
+					if (element) {
+						return element;
+					}
+				}
+			}
+
+			for (var j = 0; j < selectors.length; j++) {
+				if (selectors[j].type === "TextQuoteSelector") {
+					var exact = selectors[j].exact;
+					var found = paragraphs().filter(function (p) {
+						return text(p).indexOf(exact) === 0;
+					});
+
+					if (found.length) {
+						return found[0];
+					}
+				}
+			}
+
+			return null;
+		}
+
+		function send(method, target, body) {
+			return fetch(target, {
+				method: method,
+				credentials: "same-origin",
+				headers: {"Accept": "application/json", "Content-Type": "application/json"},
+				body: JSON.stringify(body)
+			}).then(function (response) {
+				return response.ok ? response.json() : null;
+			});
+		}
+
+		function element(name, className, content) {
+			var e = document.createElement(name);
+
+			if (className) {
+				e.className = className;
+			}
+
+			if (content) {
+				e.textContent = content;
+			}
+
+			return e;
+		}
+
+		function composer(label, onsubmit) {
+			var form = element("form");
+			var input = element("textarea");
+			var submit = element("button", null, label);
+
+			input.rows = 2;
+			input.required = true;
+			submit.type = "submit";
+
+			form.appendChild(input);
+			form.appendChild(submit);
+			form.addEventListener("submit", function (e) {
+				e.preventDefault();
+
+				if (input.value.trim()) {
+					onsubmit(input.value);
+				}
+			});
+
+			return form;
+		}
+
+		fetch(url + "?source=" + encodeURIComponent(source), {
+			credentials: "same-origin",
+			headers: {"Accept": "application/json"}
+		}).then(function (response) {
+			return response.ok ? response.json() : null;
+		}).then(function (threads) {
+			// Without threads, the reader is not signed in or there is nowhere to keep them
+			if (!threads) {
+				return;
+			}
+
+			var byParagraph = new Map();
+
+			threads.forEach(function (t) {
+				var p = locate(t);
+
+				if (p) {
+					byParagraph.set(p, (byParagraph.get(p) || []).concat([t]));
+				}
+			});
+
+			paragraphs().forEach(function (p) {
+				var toggle = element("button", "library-ui library-thread-toggle");
+				var panel = null;
+
+				toggle.type = "button";
+
+				function list() {
+					return byParagraph.get(p) || [];
+				}
+
+				function label() {
+					var open = list().filter(function (t) {
+						return !t.resolved;
+					}).length;
+
+					toggle.textContent = open ? "💬 " + open : list().length ? "✓" : "+";
+					toggle.title = list().length + " comment thread(s)";
+				}
+
+				function replace(thread) {
+					byParagraph.set(p, list().map(function (t) {
+						return t.id === thread.id ? thread : t;
+					}));
+					label();
+					render();
+				}
+
+				function render() {
+					var fresh = element("div", "library-ui library-threads");
+
+					list().forEach(function (t) {
+						var thread = element("div", "library-thread");
+
+						if (t.resolved) {
+							thread.dataset.resolved = "";
+						}
+
+						t.comments.forEach(function (c) {
+							var comment = element("div", "library-comment");
+
+							comment.appendChild(element("strong", null, c.author.name || c.author.email || c.author.subject));
+							comment.appendChild(document.createTextNode(": " + c.text));
+							thread.appendChild(comment);
+						});
+
+						var resolve = element("button", null, t.resolved ? "Reopen" : "Resolve");
+
+						resolve.type = "button";
+						resolve.addEventListener("click", function () {
+							send("PATCH", url + "/" + encodeURIComponent(t.id), {resolved: !t.resolved}).then(function (u) {
+								if (u) {
+									replace(u);
+								}
+							});
+						});
+
+						if (!t.resolved) {
+							thread.appendChild(composer("Reply", function (value) {
+								send("POST", url + "/" + encodeURIComponent(t.id) + "/comments", {text: value}).then(function (u) {
+									if (u) {
+										replace(u);
+									}
+								});
+							}));
+						}
+
+						thread.appendChild(resolve);
+						fresh.appendChild(thread);
+					});
+
+					fresh.appendChild(composer("Start a thread", function (value) {
+						var selector = [{type: "TextQuoteSelector", exact: text(p).slice(0, quoteLength)}];
+
+						if (p.id) {
+							selector.unshift({type: "FragmentSelector", value: p.id});
+						}
+
+						send("POST", url, {text: value, target: {source: source, selector: selector}}).then(function (t) {
+							if (t) {
+								byParagraph.set(p, list().concat([t]));
+								label();
+								render();
+							}
+						});
+					}));
+
+					if (panel) {
+						panel.parentNode.replaceChild(fresh, panel);
+					} else {
+						p.parentNode.insertBefore(fresh, p.nextSibling);
+					}
+
+					panel = fresh;
+				}
+
+				toggle.addEventListener("click", function () {
+					if (panel) {
+						panel.parentNode.removeChild(panel);
+						panel = null;
+						delete toggle.dataset.open;
+
+						return;
+					}
+
+					toggle.dataset.open = "";
+					render();
+				});
+
+				label();
+				p.insertBefore(toggle, p.firstChild);
+			});
+		});
+	});
+})(document.currentScript);
+//]]>
+</script>
+`
+
+const injectGoogleAnalytics = `
+<script async src="https://www.googletagmanager.com/gtag/js?id=%[1]s"></script>
+<script>
+	window.dataLayer = window.dataLayer || [];
+	function gtag(){dataLayer.push(arguments);}
+	gtag('js', new Date());
+
+	gtag('config', '%[1]s');
+</script>
+`
+
+// Injection is the additional markup that is added to every document in a book as it is rendered
+type Injection struct {
+	// Head are the snippets of HTML appended to the head of each document
+	Head []string
+
+	// Body are the snippets of HTML appended to the body of each document
+	Body []string
+}
+
+// NewInjection creates the markup to add to every document. Without options, nothing is added.
+func NewInjection(options ...func(*Injection) error) (*Injection, error) {
+	i := &Injection{}
+
+	for _, o := range options {
+		if err := o(i); err != nil {
+			return nil, errors.Wrap(err, "unable to persist option to Injection")
+		}
+	}
+
+	return i, nil
+}
+
+// WithDefaults adds a viewport and a stylesheet that keeps text to a readable width
+func WithDefaults() func(*Injection) error {
+	return WithHead(injectDefaults)
+}
+
+// WithGoogleAnalytics adds the Google Analytics tracking script for the property with the supplied ID
+func WithGoogleAnalytics(id string) func(*Injection) error {
+	return func(i *Injection) error {
+		for _, r := range id {
+			if !strings.ContainsRune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-", r) {
+				return errors.Errorf("invalid google analytics id %q", id)
+			}
+		}
+
+		return WithHead(fmt.Sprintf(injectGoogleAnalytics, id))(i)
+	}
+}
+
+// WithStylesheet adds the content of the stylesheet at path to the head of each document
+func WithStylesheet(path string) func(*Injection) error {
+	return func(i *Injection) error {
+		css, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			return errors.Wrap(err, "unable to read stylesheet")
+		}
+
+		// Escape the stylesheet as text, so that it cannot close the style element it is placed in.
+		return WithHead(fmt.Sprintf(
+			"<style type=\"text/css\">\n%s\n</style>",
+			strings.Replace(string(css), "</", "<\\/", -1),
+		))(i)
+	}
+}
+
+// WithHead adds a snippet of HTML to the head of each document
+func WithHead(snippet string) func(*Injection) error {
+	return func(i *Injection) error {
+		if len(strings.TrimSpace(snippet)) > 0 {
+			i.Head = append(i.Head, snippet)
+		}
+
+		return nil
+	}
+}
+
+// WithBody adds a snippet of HTML to the end of the body of each document
+func WithBody(snippet string) func(*Injection) error {
+	return func(i *Injection) error {
+		if len(strings.TrimSpace(snippet)) > 0 {
+			i.Body = append(i.Body, snippet)
+		}
+
+		return nil
+	}
+}
+
+// WithTemplates adds the snippets found in dir. Any of head.html, body.html and style.css may be present.
+func WithTemplates(dir string) func(*Injection) error {
+	return func(i *Injection) error {
+		if _, err := os.Stat(dir); err != nil {
+			return errors.Wrap(err, "unable to read templates")
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, InjectTemplateStylesheet)); err == nil {
+			if err := WithStylesheet(filepath.Join(dir, InjectTemplateStylesheet))(i); err != nil {
+				return err
+			}
+		}
+
+		for name, add := range map[string]func(string) func(*Injection) error{
+			InjectTemplateHead: WithHead,
+			InjectTemplateBody: WithBody,
+		} {
+			snippet, err := ioutil.ReadFile(filepath.Join(dir, name))
+
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			if err != nil {
+				return errors.Wrapf(err, "unable to read template %s", name)
+			}
+
+			if err := add(string(snippet))(i); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// WithInjection sets the markup that is added to every document as it is rendered
+func WithInjection(i *Injection) func(*Book) error {
+	return func(h *Book) error {
+		h.injection = i
+
+		return nil
+	}
+}
+
+// head returns the nodes to append to the head of document, relative to the content root, in a book whose API is at
+// api. The scripts that record what readers do are only added if there is an API for them to record it with.
+func (i *Injection) head(api string, document string) ([]*html.Node, error) {
+	snippets := []string{injectLibrary}
+
+	if len(api) > 0 {
+		snippets = append(snippets, injectProgress, injectAnnotations, injectThreads)
+	}
+
+	library, err := fragments(atom.Head, snippets)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, n := range library {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Script {
+			n.Attr = append(n.Attr,
+				html.Attribute{Key: attributeAPI, Val: api},
+				html.Attribute{Key: attributeDocument, Val: document},
+			)
+		}
+	}
+
+	nodes, err := fragments(atom.Head, i.Head)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(library, nodes...), nil
+}
+
+// body returns the nodes to append to the body of a document
+func (i *Injection) body() ([]*html.Node, error) {
+	return fragments(atom.Body, i.Body)
+}
+
+// html returns the snippets for inclusion in pages generated by the library itself, their scripts marked to be given
+// the nonce of each request
+func (i *Injection) html(api string, document string) (template.HTML, template.HTML, error) {
+	render := func(nodes []*html.Node, err error) (template.HTML, error) {
+		if err != nil {
+			return "", err
+		}
+
+		addNonce(nodes...)
+
+		b := &strings.Builder{}
+
+		for _, n := range nodes {
+			if err := html.Render(b, n); err != nil {
+				return "", errors.Wrap(err, "unable to render injected html")
+			}
+		}
+
+		return template.HTML(b.String()), nil
+	}
+
+	head, err := render(i.head(api, document))
+
+	if err != nil {
+		return "", "", err
+	}
+
+	body, err := render(i.body())
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return head, body, nil
+}
+
+// fragments parses snippets as children of an element, returning fresh nodes each time so they can be added to a
+// document.
+func fragments(context atom.Atom, snippets []string) ([]*html.Node, error) {
+	nodes := []*html.Node{}
+
+	for _, s := range snippets {
+		n, err := html.ParseFragment(strings.NewReader(s), &html.Node{
+			Type:     html.ElementNode,
+			Data:     context.String(),
+			DataAtom: context,
+		})
+
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse injected html")
+		}
+
+		nodes = append(nodes, n...)
+	}
+
+	return nodes, nil
+}
@@ -0,0 +1,370 @@
+package book
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	extTypeEPUB = ".epub"
+)
+
+// ReloadGracePeriod is the longest a replaced book is kept open for requests still reading from it. It is usually
+// closed long before, once the last of them is done.
+const ReloadGracePeriod = time.Hour
+
+// DefaultFetchInterval is how often books from remote sources are checked for changes, unless configured otherwise
+const DefaultFetchInterval = 5 * time.Minute
+
+// Library is a collection of books, each of which is addressed by its slug
+type Library struct {
+	// Directories that are searched for books
+	dirs []string
+
+	// Options applied to every book as it is opened
+	options []func(*Book) error
+
+	// Options applied to particular books as they are opened, after those applied to every book, by slug
+	slugOptions map[string][]func(*Book) error
+
+	// Where each book is fetched from, by slug
+	sources map[string]Source
+
+	// The version of books that failed to open, by source, so they are not retried until they change again
+	failed map[string]Version
+
+	// How often books from remote sources are checked for changes, and when each was last checked, by source
+	fetchInterval time.Duration
+	fetched       map[string]time.Time
+
+	mu    sync.RWMutex
+	books map[string]*Book
+}
+
+// NewLibrary creates a new collection of books
+func NewLibrary(options ...func(*Library) error) (*Library, error) {
+	l := &Library{
+		sources:       map[string]Source{},
+		failed:        map[string]Version{},
+		fetchInterval: DefaultFetchInterval,
+		fetched:       map[string]time.Time{},
+		books:         map[string]*Book{},
+		slugOptions:   map[string][]func(*Book) error{},
+	}
+
+	for _, o := range options {
+		if err := o(l); err != nil {
+			return nil, errors.Wrap(err, "unable to persist option to Library")
+		}
+	}
+
+	// Check required properties
+	if len(l.books) == 0 {
+		return nil, errors.New("cannot create library: no books supplied")
+	}
+
+	return l, nil
+}
+
+// WithBookOptions applies options to every book in the library. It must be supplied before any books are added.
+func WithBookOptions(options ...func(*Book) error) func(*Library) error {
+	return func(l *Library) error {
+		l.options = append(l.options, options...)
+
+		return nil
+	}
+}
+
+// WithBookOptionsFor applies options to the book addressed by slug, after those applied to every book. It must be
+// supplied before any books are added.
+func WithBookOptionsFor(slug string, options ...func(*Book) error) func(*Library) error {
+	return func(l *Library) error {
+		l.slugOptions[slug] = append(l.slugOptions[slug], options...)
+
+		return nil
+	}
+}
+
+// WithFetchInterval sets how often books from remote sources are checked for changes. Books on local disk are checked
+// every time the library is.
+func WithFetchInterval(interval time.Duration) func(*Library) error {
+	return func(l *Library) error {
+		l.fetchInterval = interval
+
+		return nil
+	}
+}
+
+// WithPaths adds the books at each of the supplied paths to the library
+func WithPaths(paths ...string) func(*Library) error {
+	return func(l *Library) error {
+		for _, p := range paths {
+			if err := l.add(NewFileSource(p)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// WithSources adds the books fetched from each of the supplied sources to the library
+func WithSources(sources ...Source) func(*Library) error {
+	return func(l *Library) error {
+		for _, src := range sources {
+			if err := l.add(src); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// WithDirectory adds every book found in the supplied directory to the library
+func WithDirectory(dir string) func(*Library) error {
+	return func(l *Library) error {
+		paths, err := glob(dir)
+
+		if err != nil {
+			return err
+		}
+
+		l.dirs = append(l.dirs, dir)
+
+		return WithPaths(paths...)(l)
+	}
+}
+
+// Books returns all books in the library, ordered by their slug
+func (l *Library) Books() []*Book {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	books := make([]*Book, 0, len(l.books))
+
+	for _, b := range l.books {
+		books = append(books, b)
+	}
+
+	sort.Slice(books, func(i, j int) bool {
+		return books[i].Slug < books[j].Slug
+	})
+
+	return books
+}
+
+// Book returns the book addressed by slug, if it is in the library
+func (l *Library) Book(slug string) (*Book, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	b, ok := l.books[slug]
+
+	return b, ok
+}
+
+// Acquire returns the book addressed by slug, if it is in the library, for a request that reads from it. The version
+// returned is kept open until it is released, even if it is replaced in the meantime.
+func (l *Library) Acquire(slug string) (*Book, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	b, ok := l.books[slug]
+
+	if ok {
+		b.acquire()
+	}
+
+	return b, ok
+}
+
+// Watch checks the books on disk every interval, swapping in a freshly opened copy of any book that has changed and
+// adding any book that has appeared in one of the library directories. Books from remote sources are checked at the
+// fetch interval instead, if that is longer. It returns when done is closed.
+//
+// Books that fail to open are logged and the previous version continues to be served.
+func (l *Library) Watch(interval time.Duration, done <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			l.reload()
+		}
+	}
+}
+
+func (l *Library) reload() {
+	// Pick up books that have been added to the library directories
+	for _, dir := range l.dirs {
+		paths, err := glob(dir)
+
+		if err != nil {
+			log.Printf("unable to reload library: %s", err)
+			continue
+		}
+
+		for _, p := range paths {
+			if _, ok := l.sources[Slug(p)]; ok {
+				continue
+			}
+
+			err := l.add(NewFileSource(p))
+
+			if errors.Cause(err) == ErrNotModified {
+				continue
+			}
+
+			if err != nil {
+				log.Printf("unable to add book: %s", err)
+				continue
+			}
+
+			log.Printf("added book %s from %s", Slug(p), p)
+		}
+	}
+
+	for _, old := range l.Books() {
+		if !l.due(old.source) {
+			continue
+		}
+
+		err := l.replace(old)
+
+		if errors.Cause(err) == ErrNotModified {
+			continue
+		}
+
+		if err != nil {
+			log.Printf("unable to reload book %s, continuing to serve previous version: %s", old.Slug, err)
+			continue
+		}
+
+		log.Printf("reloaded book %s from %s", old.Slug, old.Path)
+	}
+}
+
+// due indicates whether it is time to check src for changes, and if so, records that it is being checked
+func (l *Library) due(src Source) bool {
+	if _, ok := src.(*FileSource); ok {
+		return true
+	}
+
+	if t, ok := l.fetched[src.String()]; ok && time.Since(t) < l.fetchInterval {
+		return false
+	}
+
+	l.fetched[src.String()] = time.Now()
+
+	return true
+}
+
+// replace fetches the book again if it has changed and, if it opens successfully, swaps it in for old.
+func (l *Library) replace(old *Book) error {
+	b, err := l.open(old.source, old.Slug, old.version)
+
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.books[old.Slug] = b
+	l.mu.Unlock()
+
+	// Requests that started before the swap may still be reading from the old copy
+	old.retire()
+
+	return nil
+}
+
+func (l *Library) add(src Source) error {
+	slug := Slug(src.Name())
+
+	if s, ok := l.sources[slug]; ok && s.String() != src.String() {
+		return errors.Errorf("cannot add %s to library: slug %s already in use", src, slug)
+	}
+
+	b, err := l.open(src, slug, Version{})
+
+	if err == ErrNotModified {
+		return err
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "unable to add %s to library", src)
+	}
+
+	l.sources[slug] = src
+
+	l.mu.Lock()
+	l.books[slug] = b
+	l.mu.Unlock()
+
+	return nil
+}
+
+// open fetches the book from src and opens it, unless it has not changed since the version since, or since the version
+// that last failed to open, in which case it returns ErrNotModified.
+func (l *Library) open(src Source, slug string, since Version) (*Book, error) {
+	if v, ok := l.failed[src.String()]; ok {
+		since = v
+	}
+
+	f, err := src.Fetch(since)
+
+	if err != nil {
+		return nil, err
+	}
+
+	options := append([]func(*Book) error{withFetched(src, f), WithSlug(slug)}, l.options...)
+	b, err := New(append(options, l.slugOptions[slug]...)...)
+
+	if err != nil {
+		l.failed[src.String()] = f.Version
+		return nil, err
+	}
+
+	delete(l.failed, src.String())
+
+	if removed := 0; len(b.Sanitised()) > 0 {
+		for _, s := range b.Sanitised() {
+			removed += s.Count
+		}
+
+		log.Printf("removed %d untrusted elements and attributes from book %s; inspect it to see which", removed, slug)
+	}
+
+	return b, nil
+}
+
+func glob(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, fmt.Sprintf("*%s", extTypeEPUB)))
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list books in %s", dir)
+	}
+
+	return paths, nil
+}
+
+// Slug returns the URL safe name of the book at path, derived from its file name
+func Slug(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	// Collapse everything that is not a letter or a digit into a single dash
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), "-")
+}
@@ -0,0 +1,51 @@
+package book
+
+import (
+	"sync"
+)
+
+// keyLocks serialises what is done for each key, so that something asked for by several requests at once is only made
+// once, without requests for anything else waiting for it
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+// keyLock is the lock for a single key, and how many are holding or waiting for it
+type keyLock struct {
+	sync.Mutex
+	users int
+}
+
+// lock waits until nothing else holds the lock for key, and takes it. The function returned releases it.
+func (k *keyLocks) lock(key string) func() {
+	k.mu.Lock()
+
+	if k.locks == nil {
+		k.locks = map[string]*keyLock{}
+	}
+
+	l, ok := k.locks[key]
+
+	if !ok {
+		l = &keyLock{}
+		k.locks[key] = l
+	}
+
+	l.users++
+	k.mu.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+
+		k.mu.Lock()
+		defer k.mu.Unlock()
+
+		// Locks are only kept while they are needed, so that there is not one for every key ever asked for
+		if l.users--; l.users == 0 {
+			delete(k.locks, key)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package book
+
+import (
+	"strings"
+)
+
+// ManifestItem is a file listed in the manifest of a book
+type ManifestItem struct {
+	// ID is the identifier of the item in the manifest
+	ID string `json:"id"`
+
+	// Path is the location of the file relative to the content root
+	Path string `json:"path"`
+
+	// MediaType is the declared type of the file
+	MediaType string `json:"media_type"`
+
+	// Properties describe the role of the file, such as "nav" or "cover-image"
+	Properties []string `json:"properties,omitempty"`
+
+	// Size is the size of the file once extracted from the book, in bytes
+	Size int64 `json:"size"`
+
+	// Missing indicates that the file is listed, but not in the book
+	Missing bool `json:"missing,omitempty"`
+}
+
+// Manifest returns every file the book lists in its manifest, in the order it lists them
+func (h Book) Manifest() []ManifestItem {
+	items := []ManifestItem{}
+
+	for _, m := range h.EPub.Opf.Manifest {
+		u, err := resolve("", m.Href)
+
+		if err != nil {
+			continue
+		}
+
+		item := ManifestItem{
+			ID:         m.ID,
+			Path:       u.Path,
+			MediaType:  m.MediaType,
+			Properties: strings.Fields(m.Properties),
+		}
+
+		if f := h.entry(u.Path); f != nil {
+			item.Size = int64(f.UncompressedSize64)
+		} else {
+			item.Missing = true
+		}
+
+		items = append(items, item)
+	}
+
+	return items
+}
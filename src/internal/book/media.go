@@ -0,0 +1,111 @@
+package book
+
+import (
+	"archive/zip"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const (
+	mediaTypeEPUB     = "application/epub+zip"
+	mediaTypeHTML     = "text/html"
+	mediaTypeXHTML    = "application/xhtml+xml"
+	mediaTypeSVG      = "image/svg+xml"
+	mediaTypeDownload = "application/octet-stream"
+
+	// sniffLength is as much of a file as is read to tell what it is, which is all browsers would read
+	sniffLength = 512
+)
+
+// passiveTypes are the media types browsers only ever show or play, and never run anything in. They are the only
+// types the files of an untrusted book are served as, other than the documents that have been sanitised.
+var passiveTypes = map[string]bool{
+	"image/apng":                    true,
+	"image/avif":                    true,
+	"image/bmp":                     true,
+	"image/gif":                     true,
+	"image/jpeg":                    true,
+	"image/png":                     true,
+	"image/webp":                    true,
+	"image/x-icon":                  true,
+	"image/vnd.microsoft.icon":      true,
+	"audio/aac":                     true,
+	"audio/flac":                    true,
+	"audio/mp4":                     true,
+	"audio/mpeg":                    true,
+	"audio/ogg":                     true,
+	"audio/wave":                    true,
+	"audio/wav":                     true,
+	"audio/webm":                    true,
+	"video/mp4":                     true,
+	"video/ogg":                     true,
+	"video/webm":                    true,
+	"font/collection":               true,
+	"font/otf":                      true,
+	"font/ttf":                      true,
+	"font/woff":                     true,
+	"font/woff2":                    true,
+	"application/font-sfnt":         true,
+	"application/font-woff":         true,
+	"application/vnd.ms-fontobject": true,
+	"application/vnd.ms-opentype":   true,
+	"application/x-font-otf":        true,
+	"application/x-font-ttf":        true,
+	"text/css":                      true,
+	"text/plain":                    true,
+}
+
+// mediaType returns the type the file f is served as; the one its extension is known by or, failing that, the one its
+// content looks like. It is never empty, as browsers left to guess may take a file for a document.
+func mediaType(f *zip.File) string {
+	if t := mime.TypeByExtension(path.Ext(f.Name)); len(t) > 0 {
+		return t
+	}
+
+	r, err := f.Open()
+
+	if err != nil {
+		return mediaTypeDownload
+	}
+
+	defer r.Close()
+
+	buf := make([]byte, sniffLength)
+	n, _ := io.ReadFull(r, buf)
+
+	return http.DetectContentType(buf[:n])
+}
+
+// baseType returns contentType without any parameters, such as its charset
+func baseType(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+
+	return t
+}
+
+// document indicates whether a file served as contentType is a document browsers render as a page
+func document(contentType string) bool {
+	switch baseType(contentType) {
+	case mediaTypeHTML, mediaTypeXHTML:
+		return true
+	}
+
+	return false
+}
+
+// passive indicates whether a file served as contentType is only ever shown or played by browsers
+func passive(contentType string) bool {
+	return passiveTypes[baseType(contentType)]
+}
+
+// raster indicates whether a file served as contentType is an image made of pixels, which can never run anything
+func raster(contentType string) bool {
+	return passive(contentType) && strings.HasPrefix(baseType(contentType), "image/")
+}
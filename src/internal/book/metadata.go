@@ -0,0 +1,163 @@
+package book
+
+import (
+	"encoding/xml"
+	"path"
+	"strings"
+
+	"github.com/kapmahc/epub"
+	"github.com/pkg/errors"
+)
+
+const (
+	metaPropertyModified = "dcterms:modified"
+	dateEventModified    = "modification"
+)
+
+// Metadata is the description of a book, as declared in its package document
+type Metadata struct {
+	// Title is the primary title of the book
+	Title string `json:"title"`
+
+	// Creators are the people primarily responsible for the book
+	Creators []Creator `json:"creators"`
+
+	// Language are the languages the book is written in
+	Language []string `json:"language"`
+
+	// Identifiers are the identifiers of the book, such as a UUID or ISBN
+	Identifiers []Identifier `json:"identifiers"`
+
+	// Modified is when the book was last modified, if it says
+	Modified string `json:"modified,omitempty"`
+
+	// Description is a summary of the book
+	Description string `json:"description,omitempty"`
+
+	// Publisher is who published the book
+	Publisher string `json:"publisher,omitempty"`
+
+	// Subjects are the topics of the book
+	Subjects []string `json:"subjects"`
+
+	// Rights is the copyright statement of the book
+	Rights string `json:"rights,omitempty"`
+}
+
+// Creator is a person responsible for a book
+type Creator struct {
+	Name   string `json:"name"`
+	FileAs string `json:"file_as,omitempty"`
+	Role   string `json:"role,omitempty"`
+}
+
+// Identifier identifies a book
+type Identifier struct {
+	Value  string `json:"value"`
+	Scheme string `json:"scheme,omitempty"`
+}
+
+// opfMeta is the part of the package document the EPUB library does not read; EPUB 3 meta elements, which carry
+// their value as content rather than an attribute
+type opfMeta struct {
+	Meta []struct {
+		Property string `xml:"property,attr"`
+		Refines  string `xml:"refines,attr"`
+		Value    string `xml:",chardata"`
+	} `xml:"metadata>meta"`
+}
+
+// metadata reads the description of the book out of its package document
+func (h Book) metadata() (Metadata, error) {
+	m := h.EPub.Opf.Metadata
+
+	md := Metadata{
+		Title:       h.Title(),
+		Creators:    []Creator{},
+		Language:    trimAll(m.Language),
+		Identifiers: []Identifier{},
+		Description: first(m.Description),
+		Publisher:   first(m.Publisher),
+		Subjects:    trimAll(m.Subject),
+		Rights:      first(m.Rights),
+	}
+
+	for _, c := range m.Creator {
+		md.Creators = append(md.Creators, Creator{
+			Name:   strings.TrimSpace(c.Data),
+			FileAs: c.FileAs,
+			Role:   c.Role,
+		})
+	}
+
+	for _, i := range m.Identifier {
+		md.Identifiers = append(md.Identifiers, Identifier{
+			Value:  strings.TrimSpace(i.Data),
+			Scheme: i.Scheme,
+		})
+	}
+
+	modified, err := h.modified(m)
+
+	if err != nil {
+		return md, err
+	}
+
+	md.Modified = modified
+
+	return md, nil
+}
+
+// modified returns when the book was modified. EPUB 3 declares it with a meta property, and EPUB 2 with a date event.
+func (h Book) modified(m epub.Metadata) (string, error) {
+	// The package document is at the content root, by definition
+	f, err := h.Open(path.Base(h.EPub.Container.Rootfile.Path))
+
+	if err != nil {
+		return "", errors.Wrap(err, "unable to read package document")
+	}
+
+	defer f.Close()
+
+	opf := opfMeta{}
+
+	if err := xml.NewDecoder(f).Decode(&opf); err != nil {
+		return "", errors.Wrap(err, "unable to read package document")
+	}
+
+	for _, meta := range opf.Meta {
+		if meta.Property == metaPropertyModified && len(meta.Refines) == 0 {
+			return strings.TrimSpace(meta.Value), nil
+		}
+	}
+
+	for _, d := range m.Date {
+		if d.Event == dateEventModified {
+			return strings.TrimSpace(d.Data), nil
+		}
+	}
+
+	return "", nil
+}
+
+func first(values []string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			return v
+		}
+	}
+
+	return ""
+}
+
+func trimAll(values []string) []string {
+	t := []string{}
+
+	for _, v := range values {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			t = append(t, v)
+		}
+	}
+
+	return t
+}
@@ -0,0 +1,60 @@
+package book
+
+import (
+	"bytes"
+
+	"go.pkg.littleman.co/library/internal/nonce"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// noncePlaceholder stands in for the nonce of each request in the scripts the library adds to documents, which are
+// rendered long before they are requested. It is random so that books cannot guess it, and have their own scripts
+// given the nonce.
+var noncePlaceholder = func() string {
+	n, err := nonce.New()
+
+	if err != nil {
+		panic(err)
+	}
+
+	return n
+}()
+
+// nonceAttribute is how a script marked with the placeholder is rendered
+var nonceAttribute = []byte(` nonce="` + noncePlaceholder + `"`)
+
+// addNonce marks every script in the trees of nodes to be given the nonce of each request the document is served to
+func addNonce(nodes ...*html.Node) {
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Script {
+			attrs := []html.Attribute{}
+
+			for _, a := range n.Attr {
+				if a.Namespace != "" || a.Key != "nonce" {
+					attrs = append(attrs, a)
+				}
+			}
+
+			n.Attr = append(attrs, html.Attribute{Key: "nonce", Val: noncePlaceholder})
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+
+	for _, n := range nodes {
+		f(n)
+	}
+}
+
+// withNonce gives the scripts marked in content the nonce n or, if it is empty, removes the mark
+func withNonce(content []byte, n string) []byte {
+	if len(n) == 0 {
+		return bytes.Replace(content, nonceAttribute, nil, -1)
+	}
+
+	return bytes.Replace(content, nonceAttribute, []byte(` nonce="`+html.EscapeString(n)+`"`), -1)
+}
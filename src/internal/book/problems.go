@@ -0,0 +1,20 @@
+package book
+
+import (
+	"net/http"
+
+	"go.pkg.littleman.co/library/internal/problems"
+)
+
+var problem = &problems.Factory{
+	URITemplate: "https://github.com/littlemanco/library/tree/master/docs/errors/__ID__.md",
+}
+
+// problemNotFound is returned when a request is for something that is not in the book
+func problemNotFound() *problems.Problem {
+	return problem.WithEverything(
+		"Not Found",
+		"There is nothing at this address in the book.",
+		[]int{problems.AudienceConsumer, problems.AudienceAPIUser},
+	).WithStatus(http.StatusNotFound)
+}
@@ -0,0 +1,139 @@
+package book
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FetchTimeout is the longest a book may take to fetch from a remote source
+const FetchTimeout = 5 * time.Minute
+
+var fetchClient = &http.Client{Timeout: FetchTimeout}
+
+// HTTPSource is a book served over HTTP(S). It is fetched with conditional requests, so that it is only downloaded
+// again when it has changed.
+type HTTPSource struct {
+	url *url.URL
+
+	// sign authenticates each request, if the server requires it
+	sign func(*http.Request) error
+}
+
+// NewHTTPSource returns the source for the book at rawURL
+func NewHTTPSource(rawURL string) (*HTTPSource, error) {
+	u, err := url.Parse(rawURL)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid book url %s", rawURL)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("invalid book url %s: scheme must be http or https", rawURL)
+	}
+
+	return &HTTPSource{url: u}, nil
+}
+
+// String returns the URL of the book, without any credentials it contains
+func (s *HTTPSource) String() string {
+	u := *s.url
+	u.User = nil
+
+	return u.String()
+}
+
+// Name returns the file name at the end of the URL of the book
+func (s *HTTPSource) Name() string {
+	return urlName(s.url)
+}
+
+// Fetch downloads the book to a temporary file, unless the server says it has not changed since since
+func (s *HTTPSource) Fetch(since Version) (*Fetched, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url.String(), nil)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch book")
+	}
+
+	if s.sign != nil {
+		if err := s.sign(req); err != nil {
+			return nil, errors.Wrap(err, "unable to fetch book")
+		}
+	}
+
+	// Conditional headers are added after signing; they need not be signed, and do not change what is fetched.
+	if len(since.ETag) > 0 {
+		req.Header.Set("If-None-Match", since.ETag)
+	}
+
+	if !since.ModTime.IsZero() {
+		req.Header.Set("If-Modified-Since", since.ModTime.UTC().Format(http.TimeFormat))
+	}
+
+	res, err := fetchClient.Do(req)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch book")
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified {
+		return nil, ErrNotModified
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unable to fetch book: %s returned %s", s, res.Status)
+	}
+
+	v := Version{ETag: res.Header.Get("ETag")}
+
+	if t, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+		v.ModTime = t
+	}
+
+	// Servers that ignore conditional requests may still say that nothing has changed
+	if len(v.ETag) > 0 && v.ETag == since.ETag {
+		return nil, ErrNotModified
+	}
+
+	f, err := ioutil.TempFile("", "library-*"+extTypeEPUB)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch book")
+	}
+
+	digest := sha256.New()
+
+	v.Size, err = io.Copy(io.MultiWriter(f, digest), res.Body)
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, errors.Wrapf(err, "unable to fetch book from %s", s)
+	}
+
+	// Servers that do not support conditional requests at all send the same book every time
+	if v.Digest = hex.EncodeToString(digest.Sum(nil)); v.Digest == since.Digest {
+		os.Remove(f.Name())
+		return nil, ErrNotModified
+	}
+
+	// Without a modification time from the server, the book is as new as the copy of it
+	if v.ModTime.IsZero() {
+		v.ModTime = time.Now()
+	}
+
+	return &Fetched{Path: f.Name(), Version: v, Temporary: true}, nil
+}
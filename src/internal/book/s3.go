@@ -0,0 +1,230 @@
+package book
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// S3DefaultRegion is the region objects are fetched from if none is configured
+	S3DefaultRegion = "us-east-1"
+
+	// The hash of an empty payload, which is all a GET request has
+	s3EmptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	s3TimeFormat = "20060102T150405Z"
+	s3DateFormat = "20060102"
+)
+
+// S3Config is how to reach and authenticate with S3, or a compatible object store such as MinIO
+type S3Config struct {
+	// Endpoint is the URL of the object store, such as http://localhost:9000. Buckets are addressed by path under it.
+	// If empty, AWS is used and buckets are addressed by host name.
+	Endpoint string
+
+	// Region is the region the bucket is in. It defaults to S3DefaultRegion.
+	Region string
+
+	// AccessKeyID and SecretAccessKey are the credentials requests are signed with. Without them, requests are sent
+	// anonymously, which is enough for public buckets.
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// SessionToken accompanies temporary credentials
+	SessionToken string
+}
+
+// NewS3Source returns the source for the book at location, an s3://bucket/key URL. It is fetched over HTTP(S) with
+// requests signed by AWS Signature Version 4, and conditional requests as for any other HTTP source.
+func NewS3Source(location string, config S3Config) (*HTTPSource, error) {
+	u, err := url.Parse(location)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid book url %s", location)
+	}
+
+	bucket := u.Host
+	key := strings.TrimPrefix(u.Path, "/")
+
+	if u.Scheme != "s3" || len(bucket) == 0 || len(key) == 0 {
+		return nil, errors.Errorf("invalid book url %s: must be s3://bucket/key", location)
+	}
+
+	if len(config.Region) == 0 {
+		config.Region = S3DefaultRegion
+	}
+
+	var object *url.URL
+
+	if len(config.Endpoint) > 0 {
+		endpoint, err := url.Parse(config.Endpoint)
+
+		if err != nil || len(endpoint.Host) == 0 {
+			return nil, errors.Errorf("invalid s3 endpoint %s", config.Endpoint)
+		}
+
+		object = &url.URL{
+			Scheme: endpoint.Scheme,
+			Host:   endpoint.Host,
+			Path:   strings.TrimSuffix(endpoint.Path, "/") + "/" + bucket + "/" + key,
+		}
+	} else {
+		object = &url.URL{
+			Scheme: "https",
+			Host:   fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, config.Region),
+			Path:   "/" + key,
+		}
+	}
+
+	// S3 expects every character of the key other than the unreserved ones to be encoded, and signs it that way
+	object.RawPath = s3Escape(object.Path, true)
+
+	s := &HTTPSource{url: object}
+
+	if len(config.AccessKeyID) > 0 {
+		s.sign = func(r *http.Request) error {
+			return s3Sign(r, config, time.Now())
+		}
+	}
+
+	return s, nil
+}
+
+// s3Sign adds an AWS Signature Version 4 to a request with no body, signing every header it has so far.
+//
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func s3Sign(r *http.Request, config S3Config, now time.Time) error {
+	if len(config.SecretAccessKey) == 0 {
+		return errors.New("unable to sign request: no secret access key")
+	}
+
+	now = now.UTC()
+	date := now.Format(s3DateFormat)
+	scope := strings.Join([]string{date, config.Region, "s3", "aws4_request"}, "/")
+
+	r.Header.Set("X-Amz-Date", now.Format(s3TimeFormat))
+	r.Header.Set("X-Amz-Content-Sha256", s3EmptyPayload)
+
+	if len(config.SessionToken) > 0 {
+		r.Header.Set("X-Amz-Security-Token", config.SessionToken)
+	}
+
+	canonical, signed := s3CanonicalRequest(r)
+	toSign := s3StringToSign(now, scope, canonical)
+
+	r.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		config.AccessKeyID,
+		scope,
+		signed,
+		s3Signature(config, date, toSign),
+	))
+
+	return nil
+}
+
+// s3CanonicalRequest returns the form of a request with no body that is signed, and the names of the headers signed
+// with it; every header it has so far
+func s3CanonicalRequest(r *http.Request) (string, string) {
+	// The host is sent separately from the other headers, but must be signed with them
+	headers := map[string]string{"host": r.URL.Host}
+
+	for k, v := range r.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+
+	names := make([]string, 0, len(headers))
+
+	for k := range headers {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	canonical := strings.Builder{}
+	canonical.WriteString(r.Method + "\n")
+	canonical.WriteString(r.URL.EscapedPath() + "\n")
+	canonical.WriteString(s3Query(r.URL.Query()) + "\n")
+
+	for _, k := range names {
+		canonical.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+
+	signed := strings.Join(names, ";")
+
+	canonical.WriteString("\n" + signed + "\n" + s3EmptyPayload)
+
+	return canonical.String(), signed
+}
+
+// s3StringToSign returns what is signed for the canonical request made at now, within scope
+func s3StringToSign(now time.Time, scope string, canonical string) string {
+	hash := sha256.Sum256([]byte(canonical))
+
+	return strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.UTC().Format(s3TimeFormat),
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+}
+
+// s3Signature signs toSign with a key derived from the secret access key for the date and region
+func s3Signature(config S3Config, date string, toSign string) string {
+	key := []byte("AWS4" + config.SecretAccessKey)
+
+	for _, part := range []string{date, config.Region, "s3", "aws4_request"} {
+		key = s3HMAC(key, part)
+	}
+
+	return hex.EncodeToString(s3HMAC(key, toSign))
+}
+
+func s3HMAC(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
+
+// s3Query returns the canonical form of a query string; sorted by name, with everything encoded
+func s3Query(q url.Values) string {
+	pairs := []string{}
+
+	for k, vs := range q {
+		for _, v := range vs {
+			pairs = append(pairs, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape encodes everything but the unreserved characters, and slashes if they separate the segments of a path
+func s3Escape(s string, path bool) string {
+	b := strings.Builder{}
+
+	for _, c := range []byte(s) {
+		unreserved := ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || strings.IndexByte("-_.~", c) >= 0
+
+		if unreserved || (path && c == '/') {
+			b.WriteByte(c)
+			continue
+		}
+
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,581 @@
+package book
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+// Trust is how far the content of a book is trusted to run on the same origin as the library, and so how much of it is
+// removed before it is served
+type Trust int
+
+const (
+	// TrustUntrusted removes anything that could run a script, keeping only the markup on the allow-lists. It is the
+	// default.
+	TrustUntrusted Trust = iota
+
+	// TrustStrict also removes anything embedded from outside the book, such as images that track readers
+	TrustStrict
+
+	// TrustTrusted serves the book as it is, scripts and all
+	TrustTrusted
+)
+
+const (
+	// SanitisedElement is the kind of an element that was removed along with its content
+	SanitisedElement = "element"
+
+	// SanitisedUnwrapped is the kind of an element that was removed, keeping its content
+	SanitisedUnwrapped = "unwrapped"
+
+	// SanitisedAttribute is the kind of an attribute that was removed
+	SanitisedAttribute = "attribute"
+
+	// SanitisedDocument is the kind of a document that could not be sanitised, and was replaced entirely
+	SanitisedDocument = "document"
+
+	reasonNotAllowed = "not allowed"
+	reasonScript     = "could run a script"
+	reasonRemote     = "embeds content from outside the book"
+
+	// prefixLibrary begins the names the library gives what it adds to documents
+	prefixLibrary = "library-"
+
+	namespaceSVG = "http://www.w3.org/2000/svg"
+)
+
+var trustNames = map[Trust]string{
+	TrustUntrusted: "untrusted",
+	TrustStrict:    "strict",
+	TrustTrusted:   "trusted",
+}
+
+// ParseTrust returns the trust level called name; "untrusted", "strict" or "trusted"
+func ParseTrust(name string) (Trust, error) {
+	for t, n := range trustNames {
+		if n == strings.ToLower(strings.TrimSpace(name)) {
+			return t, nil
+		}
+	}
+
+	return TrustUntrusted, errors.Errorf("unknown trust level %q, must be untrusted, strict or trusted", name)
+}
+
+// String returns the name of the trust level
+func (t Trust) String() string {
+	return trustNames[t]
+}
+
+// Sanitised is something that was removed from a document of a book because the book is not trusted
+type Sanitised struct {
+	// Path is the location of the document relative to the content root
+	Path string `json:"path"`
+
+	// Kind is what was removed; SanitisedElement, SanitisedUnwrapped, SanitisedAttribute or SanitisedDocument
+	Kind string `json:"kind"`
+
+	// Name is the name of the element, or of the attribute and the element it was on, such as "a[onclick]"
+	Name string `json:"name"`
+
+	// Reason is why it was removed
+	Reason string `json:"reason"`
+
+	// Count is how many times it was removed from the document
+	Count int `json:"count"`
+}
+
+// Elements that are removed along with everything in them, rather than leaving their content in place
+var sanitiseDropped = set(
+	"script", "noscript", "template", "iframe", "frame", "frameset", "object", "embed", "applet", "base", "portal",
+	"foreignObject", "handler", "listener", "animate", "animateMotion", "animateTransform", "set", "discard",
+	"maction", "semantics", "annotation-xml",
+)
+
+// Elements that may be served from an untrusted book; HTML, SVG and MathML without any way of running a script
+var sanitiseElements = set(
+	// HTML
+	"html", "head", "body", "title", "meta", "link", "style", "div", "span", "p", "br", "hr", "wbr", "h1", "h2", "h3",
+	"h4", "h5", "h6", "hgroup", "header", "footer", "main", "nav", "section", "article", "aside", "address",
+	"blockquote", "figure", "figcaption", "pre", "code", "kbd", "samp", "var", "em", "strong", "b", "i", "u", "s",
+	"strike", "small", "big", "tt", "sub", "sup", "mark", "abbr", "acronym", "cite", "q", "dfn", "time", "data", "del",
+	"ins", "bdi", "bdo", "ruby", "rb", "rt", "rtc", "rp", "a", "img", "picture", "source", "audio", "video", "track",
+	"map", "area", "ul", "ol", "li", "dl", "dt", "dd", "menu", "table", "caption", "colgroup", "col", "thead", "tbody",
+	"tfoot", "tr", "th", "td", "details", "summary", "center", "font", "label", "input", "button", "select", "option",
+	"optgroup", "textarea", "fieldset", "legend", "output", "meter", "progress", "canvas",
+	// SVG
+	"svg", "g", "defs", "symbol", "use", "image", "switch", "desc", "metadata", "path", "rect", "circle", "ellipse",
+	"line", "polyline", "polygon", "text", "tspan", "textPath", "linearGradient", "radialGradient", "stop", "clipPath",
+	"mask", "pattern", "marker", "filter", "feBlend", "feColorMatrix", "feComponentTransfer", "feComposite",
+	"feConvolveMatrix", "feDiffuseLighting", "feDisplacementMap", "feDistantLight", "feDropShadow", "feFlood",
+	"feFuncA", "feFuncB", "feFuncG", "feFuncR", "feGaussianBlur", "feImage", "feMerge", "feMergeNode",
+	"feMorphology", "feOffset", "fePointLight", "feSpecularLighting", "feSpotLight", "feTile", "feTurbulence", "view",
+	// MathML
+	"math", "mi", "mn", "mo", "ms", "mtext", "mspace", "mrow", "mfrac", "msqrt", "mroot", "mstyle", "merror",
+	"mpadded", "mphantom", "mfenced", "menclose", "msub", "msup", "msubsup", "munder", "mover", "munderover",
+	"mmultiscripts", "mprescripts", "none", "mtable", "mtr", "mtd", "mlabeledtr", "annotation",
+)
+
+// Attributes that may be served from an untrusted book. Those of any aria-* or data-* name are allowed too.
+var sanitiseAttributes = set(
+	// Global
+	"id", "class", "style", "title", "lang", "xml:lang", "dir", "hidden", "tabindex", "role", "accesskey", "translate",
+	"epub:type", "epub:prefix", "prefix", "vocab", "typeof", "property", "resource", "about", "xmlns", "xml:space",
+	"xml:base",
+	// HTML
+	"href", "hreflang", "rel", "rev", "type", "media", "target", "name", "alt", "src", "srcset", "sizes", "width",
+	"height", "loading", "decoding", "usemap", "ismap", "shape", "coords", "colspan", "rowspan", "headers", "scope",
+	"span", "abbr", "align", "valign", "border", "cellpadding", "cellspacing", "summary", "frame", "rules", "bgcolor",
+	"color", "face", "size", "start", "reversed", "value", "datetime", "cite", "open", "controls", "autoplay", "loop",
+	"muted", "preload", "poster", "kind", "srclang", "label", "default", "charset", "content", "http-equiv", "for",
+	"placeholder", "disabled", "readonly", "checked", "selected", "multiple", "maxlength", "min", "max", "step",
+	"pattern", "required", "wrap", "rows", "cols", "clear", "noshade", "nowrap", "compact", "char", "charoff", "axis",
+	"high", "low", "optimum", "playsinline", "crossorigin",
+	// SVG
+	"viewBox", "preserveAspectRatio", "version", "baseProfile", "x", "y", "x1", "y1", "x2", "y2", "cx", "cy", "r",
+	"rx", "ry", "fx", "fy", "fr", "d", "points", "pathLength", "transform", "fill", "fill-opacity", "fill-rule",
+	"stroke", "stroke-width", "stroke-linecap", "stroke-linejoin", "stroke-miterlimit", "stroke-dasharray",
+	"stroke-dashoffset", "stroke-opacity", "opacity", "font-family", "font-size", "font-weight", "font-style",
+	"font-variant", "font-stretch", "text-anchor", "dominant-baseline", "alignment-baseline", "baseline-shift",
+	"letter-spacing", "word-spacing", "text-decoration", "writing-mode", "dx", "dy", "rotate", "textLength",
+	"lengthAdjust", "startOffset", "method", "spacing", "side", "gradientUnits", "gradientTransform", "spreadMethod",
+	"offset", "stop-color", "stop-opacity", "patternUnits", "patternContentUnits", "patternTransform",
+	"clipPathUnits", "clip-path", "clip-rule", "clip", "mask", "maskUnits", "maskContentUnits", "markerWidth",
+	"markerHeight", "markerUnits", "refX", "refY", "orient", "marker-start", "marker-mid", "marker-end", "visibility",
+	"display", "overflow", "filter", "filterUnits", "primitiveUnits", "in", "in2", "result", "stdDeviation", "mode",
+	"operator", "k1", "k2", "k3", "k4", "values", "tableValues", "slope", "intercept", "amplitude", "exponent",
+	"flood-color", "flood-opacity", "lighting-color", "surfaceScale", "diffuseConstant", "specularConstant",
+	"specularExponent", "kernelMatrix", "kernelUnitLength", "order", "divisor", "bias", "targetX", "targetY",
+	"edgeMode", "preserveAlpha", "scale", "xChannelSelector", "yChannelSelector", "radius", "azimuth", "elevation",
+	"pointsAtX", "pointsAtY", "pointsAtZ", "limitingConeAngle", "baseFrequency", "numOctaves", "seed", "stitchTiles",
+	"vector-effect", "shape-rendering", "text-rendering", "image-rendering", "color-interpolation",
+	"color-interpolation-filters", "color-rendering", "paint-order", "mix-blend-mode", "isolation", "focusable",
+	"requiredFeatures", "requiredExtensions", "systemLanguage", "enable-background", "xlink:href", "xlink:title",
+	"xlink:type", "xlink:role", "xlink:arcrole", "xlink:show", "xlink:actuate",
+	// MathML
+	"mathvariant", "mathsize", "mathcolor", "mathbackground", "displaystyle", "scriptlevel", "notation", "close",
+	"separators", "fence", "stretchy", "symmetric", "largeop", "movablelimits", "accent", "accentunder", "lspace",
+	"rspace", "linethickness", "columnalign", "rowalign", "columnspan", "columnlines", "rowlines", "columnspacing",
+	"rowspacing", "equalrows", "equalcolumns", "minsize", "maxsize", "depth", "voffset", "encoding", "alttext",
+	"altimg", "form", "bevelled", "numalign", "denomalign", "subscriptshift", "superscriptshift", "scriptminsize",
+	"scriptsizemultiplier", "frame", "framespacing",
+)
+
+// Attributes whose value is a URL, that must be checked for schemes that run scripts
+var sanitiseURLs = set(
+	"href", "xlink:href", "src", "srcset", "poster", "cite", "altimg", "xml:base",
+)
+
+// Attributes whose value is a URL that is embedded in the document, rather than navigated to
+var sanitiseEmbedded = set("src", "srcset", "poster", "altimg")
+
+// Schemes that may be linked to. Anything without a scheme refers to the book itself.
+var sanitiseSchemes = set("http", "https", "mailto", "tel", "data")
+
+// Schemes that embed content from outside the book
+var sanitiseRemote = set("http", "https", "ftp")
+
+// Stylesheets that use any of these could run a script in some browsers
+var sanitiseCSSScript = regexp.MustCompile(`(?i)expression\s*\(|javascript\s*:|vbscript\s*:|-moz-binding|behavior\s*:`)
+
+// Stylesheets that use this embed content from outside the book
+var sanitiseCSSRemote = regexp.MustCompile(`(?i)url\(\s*['"]?\s*(https?:|ftp:)?//|@import`)
+
+// Escapes only what must be, so that whitespace, even outside the root element, is written as it was
+var (
+	xmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	xmlAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "\t", "&#9;", "\n", "&#10;", "\r", "&#13;")
+)
+
+// The characters browsers ignore within the scheme of a URL
+var sanitiseIgnored = regexp.MustCompile(`[\x00-\x20\x7f]+`)
+
+// WithTrust sets how far the content of the book is trusted, and so how much of it is removed before it is served
+func WithTrust(trust Trust) func(*Book) error {
+	return func(h *Book) error {
+		if _, ok := trustNames[trust]; !ok {
+			return errors.Errorf("unknown trust level %d", trust)
+		}
+
+		h.trust = trust
+
+		return nil
+	}
+}
+
+// Sanitised returns what was removed from the documents of the book because it is not trusted, so that the authors
+// of the book can fix it
+func (h Book) Sanitised() []Sanitised {
+	return h.sanitised
+}
+
+// sanitiser removes everything from the documents of an untrusted book that is not on the allow-lists, recording what
+// it removes
+type sanitiser struct {
+	trust Trust
+	found []Sanitised
+
+	// Where in found each thing removed was recorded, by document, kind, name and reason, so that it is counted once
+	index map[string]int
+}
+
+func newSanitiser(trust Trust) *sanitiser {
+	return &sanitiser{trust: trust, found: []Sanitised{}, index: map[string]int{}}
+}
+
+// record notes that something was removed from the document at path
+func (s *sanitiser) record(path string, kind string, name string, reason string) {
+	key := strings.Join([]string{path, kind, name, reason}, "\x00")
+
+	if i, ok := s.index[key]; ok {
+		s.found[i].Count++
+		return
+	}
+
+	s.index[key] = len(s.found)
+	s.found = append(s.found, Sanitised{Path: path, Kind: kind, Name: name, Reason: reason, Count: 1})
+}
+
+// element decides what to do with an element; whether it is kept, removed along with its content or unwrapped
+func (s *sanitiser) element(path string, name string, attr func(string) string) (keep bool, drop bool) {
+	switch {
+	case sanitiseDropped[name]:
+		s.record(path, SanitisedElement, name, reasonScript)
+		return false, true
+	case !sanitiseElements[name]:
+		s.record(path, SanitisedUnwrapped, name, reasonNotAllowed)
+		return false, false
+	case name == "meta" && len(attr("http-equiv")) > 0 && !strings.EqualFold(attr("http-equiv"), "content-type"):
+		// Refreshes and the like can navigate away or set cookies
+		s.record(path, SanitisedElement, name+"[http-equiv]", reasonNotAllowed)
+		return false, true
+	case name == "meta" && strings.HasPrefix(strings.ToLower(strings.TrimSpace(attr("name"))), prefixLibrary):
+		// The library's own names are reserved for it, so a book cannot pass itself off as the library
+		s.record(path, SanitisedElement, name+"[name]", reasonNotAllowed)
+		return false, true
+	case name == "link" && !hasProperty(strings.ToLower(attr("rel")), "stylesheet"):
+		s.record(path, SanitisedElement, name+"[rel]", reasonNotAllowed)
+		return false, true
+	case name == "link" && s.trust == TrustStrict && s.remote(attr("href")):
+		s.record(path, SanitisedElement, name, reasonRemote)
+		return false, true
+	case name == "style" && !s.css(attr("")):
+		s.record(path, SanitisedElement, name, s.cssReason(attr("")))
+		return false, true
+	}
+
+	return true, false
+}
+
+// attribute indicates whether an attribute may be kept, recording it if not
+func (s *sanitiser) attribute(path string, element string, name string, value string) bool {
+	reason := ""
+
+	switch {
+	case strings.HasPrefix(strings.ToLower(name), "on"):
+		reason = reasonScript
+	case !sanitiseAttributes[name] && !strings.HasPrefix(name, "aria-") && !strings.HasPrefix(name, "data-") &&
+		!strings.HasPrefix(name, "xmlns:"):
+		reason = reasonNotAllowed
+	case name == "style" && !s.css(value):
+		reason = s.cssReason(value)
+	case sanitiseURLs[name]:
+		reason = s.url(element, name, value)
+	}
+
+	if len(reason) == 0 {
+		return true
+	}
+
+	s.record(path, SanitisedAttribute, fmt.Sprintf("%s[%s]", element, name), reason)
+
+	return false
+}
+
+// url returns why a URL in an attribute must be removed, or nothing if it may be kept
+func (s *sanitiser) url(element string, name string, value string) string {
+	embedded := sanitiseEmbedded[name] || element == "image" || element == "feImage" || element == "use"
+	candidates := []string{value}
+
+	// Each candidate of a srcset is a URL followed by what it is a candidate for
+	if name == "srcset" {
+		candidates = []string{}
+
+		for _, c := range strings.Split(value, ",") {
+			if f := strings.Fields(c); len(f) > 0 {
+				candidates = append(candidates, f[0])
+			}
+		}
+	}
+
+	for _, c := range candidates {
+		scheme := urlScheme(c)
+
+		// Only images, sounds and the like may be data, where they are embedded; anything else could be a document with
+		// scripts in it
+		if scheme == "data" && (!embedded || element == "use" || !s.dataMedia(c)) {
+			return reasonScript
+		}
+
+		if len(scheme) > 0 && !sanitiseSchemes[scheme] {
+			return reasonScript
+		}
+
+		if embedded && s.trust == TrustStrict && sanitiseRemote[scheme] {
+			return reasonRemote
+		}
+	}
+
+	return ""
+}
+
+// dataMedia indicates whether a data URL holds an image, sound or video, which cannot run scripts when embedded
+func (s *sanitiser) dataMedia(value string) bool {
+	t := strings.ToLower(strings.TrimPrefix(sanitiseIgnored.ReplaceAllString(value, ""), "data:"))
+
+	for _, prefix := range []string{"image/", "audio/", "video/", "font/"} {
+		if strings.HasPrefix(t, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remote indicates whether a URL refers to somewhere outside the book
+func (s *sanitiser) remote(value string) bool {
+	return sanitiseRemote[urlScheme(value)] || strings.HasPrefix(strings.TrimSpace(value), "//")
+}
+
+// css indicates whether a stylesheet may be kept
+func (s *sanitiser) css(css string) bool {
+	return len(s.cssReason(css)) == 0
+}
+
+// cssReason returns why a stylesheet must be removed, or nothing if it may be kept
+func (s *sanitiser) cssReason(css string) string {
+	if sanitiseCSSScript.MatchString(css) {
+		return reasonScript
+	}
+
+	if s.trust == TrustStrict && sanitiseCSSRemote.MatchString(css) {
+		return reasonRemote
+	}
+
+	return ""
+}
+
+// urlScheme returns the scheme of a URL in lower case, as a browser would read it, or nothing if it is relative
+func urlScheme(value string) string {
+	v := sanitiseIgnored.ReplaceAllString(value, "")
+
+	if i := strings.IndexAny(v, ":/?#"); i > 0 && v[i] == ':' {
+		return strings.ToLower(v[:i])
+	}
+
+	return ""
+}
+
+// html removes everything from a parsed document that may not be served, returning what it removed
+func (s *sanitiser) html(path string, doc *html.Node) {
+	var walk func(n *html.Node)
+
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+
+			if c.Type == html.ElementNode {
+				get := func(key string) string {
+					if len(key) == 0 {
+						return text(c)
+					}
+
+					return attr(c, key)
+				}
+
+				keep, drop := s.element(path, c.Data, get)
+
+				switch {
+				case drop:
+					n.RemoveChild(c)
+				case !keep:
+					// The content of an element that is not allowed takes its place, and is sanitised in turn
+					first := c.FirstChild
+
+					for gc := c.FirstChild; gc != nil; {
+						gnext := gc.NextSibling
+						c.RemoveChild(gc)
+						n.InsertBefore(gc, c)
+						gc = gnext
+					}
+
+					n.RemoveChild(c)
+
+					if first != nil {
+						next = first
+					}
+				default:
+					attrs := c.Attr[:0]
+
+					for _, a := range c.Attr {
+						if s.attribute(path, c.Data, qualified(a.Namespace, a.Key), a.Val) {
+							attrs = append(attrs, a)
+						}
+					}
+
+					c.Attr = attrs
+					walk(c)
+				}
+			}
+
+			c = next
+		}
+	}
+
+	walk(doc)
+}
+
+// svg removes everything from an SVG document that may not be served, returning what is left. Documents that cannot
+// be parsed are replaced with an empty image, as there is no telling what a browser would make of them.
+func (s *sanitiser) svg(path string, r io.Reader) []byte {
+	out := &bytes.Buffer{}
+	d := xml.NewDecoder(r)
+
+	// Each element that is open, and whether its end tag is written
+	open := []bool{}
+	names := []string{}
+
+	// How deep within an element that is removed along with its content the decoder is
+	dropped := 0
+
+	for {
+		t, err := d.RawToken()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			s.record(path, SanitisedDocument, "svg", err.Error())
+			return []byte(`<svg xmlns="` + namespaceSVG + `"></svg>`)
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			if dropped > 0 {
+				dropped++
+				continue
+			}
+
+			name := qualified(t.Name.Space, t.Name.Local)
+			local := strings.TrimPrefix(name, "svg:")
+
+			keep, drop := s.element(path, local, func(key string) string {
+				for _, a := range t.Attr {
+					if qualified(a.Name.Space, a.Name.Local) == key {
+						return a.Value
+					}
+				}
+
+				return ""
+			})
+
+			if drop {
+				dropped = 1
+				continue
+			}
+
+			open = append(open, keep)
+			names = append(names, local)
+
+			if !keep {
+				continue
+			}
+
+			out.WriteString("<" + name)
+
+			for _, a := range t.Attr {
+				an := qualified(a.Name.Space, a.Name.Local)
+
+				if s.attribute(path, local, an, a.Value) {
+					out.WriteString(" " + an + `="` + xmlAttrEscaper.Replace(a.Value) + `"`)
+				}
+			}
+
+			out.WriteString(">")
+		case xml.EndElement:
+			if dropped > 0 {
+				dropped--
+				continue
+			}
+
+			if len(open) == 0 {
+				continue
+			}
+
+			if open[len(open)-1] {
+				out.WriteString("</" + qualified(t.Name.Space, t.Name.Local) + ">")
+			}
+
+			open = open[:len(open)-1]
+			names = names[:len(names)-1]
+		case xml.CharData:
+			if dropped > 0 {
+				continue
+			}
+
+			// Stylesheets within the image are checked as they are written
+			if len(names) > 0 && names[len(names)-1] == "style" && !s.css(string(t)) {
+				s.record(path, SanitisedElement, "style", s.cssReason(string(t)))
+				continue
+			}
+
+			out.WriteString(xmlTextEscaper.Replace(string(t)))
+		case xml.Comment:
+			if dropped == 0 {
+				out.WriteString("<!--" + strings.Replace(string(t), "--", "- -", -1) + "-->")
+			}
+		case xml.ProcInst:
+			// The declaration is kept, as it says how the document is encoded; stylesheets that could transform the
+			// document are not
+			if dropped == 0 && t.Target == "xml" {
+				out.WriteString("<?xml " + string(t.Inst) + "?>")
+			} else if dropped == 0 {
+				s.record(path, SanitisedElement, "?"+t.Target, reasonNotAllowed)
+			}
+		case xml.Directive:
+			// Document types may declare entities that expand into anything at all
+			if dropped == 0 {
+				s.record(path, SanitisedElement, "!DOCTYPE", reasonNotAllowed)
+			}
+		}
+	}
+
+	return out.Bytes()
+}
+
+// qualified returns the name of an element or attribute along with its prefix, if it has one
+func qualified(prefix string, local string) string {
+	if len(prefix) == 0 {
+		return local
+	}
+
+	return prefix + ":" + local
+}
+
+// set returns a set of the supplied members
+func set(members ...string) map[string]bool {
+	s := make(map[string]bool, len(members))
+
+	for _, m := range members {
+		s[m] = true
+	}
+
+	return s
+}
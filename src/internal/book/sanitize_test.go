@@ -0,0 +1,345 @@
+package book
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestSanitiserHTML(t *testing.T) {
+	tests := []struct {
+		name    string
+		trust   Trust
+		body    string
+		head    string
+		want    []string
+		removed []string
+		found   []string
+	}{
+		{
+			name:    "script",
+			body:    `<p>Text</p><script>alert(1)</script>`,
+			want:    []string{`<p>Text</p>`},
+			removed: []string{`<script`, `alert(1)`},
+			found:   []string{"script"},
+		},
+		{
+			name:    "noscript and iframe",
+			body:    `<noscript><p>No</p></noscript><iframe src="x.html"></iframe><p>Yes</p>`,
+			want:    []string{`<p>Yes</p>`},
+			removed: []string{`<noscript`, `<iframe`},
+			found:   []string{"noscript", "iframe"},
+		},
+		{
+			name:    "event handler attributes",
+			body:    `<p onclick="alert(1)" ONMOUSEOVER="alert(2)" class="x">Text</p><img src="a.png" onerror="alert(3)"/>`,
+			want:    []string{`<p class="x">Text</p>`, `<img src="a.png"/>`},
+			removed: []string{`alert`},
+			found:   []string{"p[onclick]", "p[onmouseover]", "img[onerror]"},
+		},
+		{
+			name: "javascript urls",
+			body: `<a href="javascript:alert(1)">One</a><a href=" java&#09;script:alert(2)">Two</a>` +
+				`<a href="JaVaScRiPt:alert(3)">Three</a><a href="vbscript:x">Four</a>`,
+			want:    []string{`<a>One</a>`, `<a>Two</a>`, `<a>Three</a>`, `<a>Four</a>`},
+			removed: []string{`script:`},
+			found:   []string{"a[href]"},
+		},
+		{
+			name:  "safe urls",
+			body:  `<a href="chapter2.xhtml#p1">One</a><a href="https://example.com/">Two</a><a href="mailto:a@b.c">Three</a>`,
+			want:  []string{`href="chapter2.xhtml#p1"`, `href="https://example.com/"`, `href="mailto:a@b.c"`},
+			found: []string{},
+		},
+		{
+			name: "data urls",
+			body: `<a href="data:text/html,&lt;script&gt;alert(1)&lt;/script&gt;">One</a>` +
+				`<img src="data:image/png;base64,AAAA"/>`,
+			want:    []string{`<a>One</a>`, `<img src="data:image/png;base64,AAAA"/>`},
+			removed: []string{`text/html`},
+			found:   []string{"a[href]"},
+		},
+		{
+			name:    "svg foreign object",
+			body:    `<svg><foreignObject><p>Inside</p></foreignObject><rect width="1"></rect></svg>`,
+			want:    []string{`<rect width="1">`},
+			removed: []string{`foreignObject`, `foreignobject`, `Inside`},
+			found:   []string{"foreignObject"},
+		},
+		{
+			name:    "svg use",
+			body:    `<svg><use href="data:image/svg+xml;base64,AAAA"></use><use href="#shape"></use></svg>`,
+			want:    []string{`<use></use>`, `<use href="#shape"></use>`},
+			removed: []string{`data:image/svg+xml`},
+			found:   []string{"use[href]"},
+		},
+		{
+			name: "svg script and handlers",
+			body: `<svg onload="alert(1)"><script>alert(2)</script>` +
+				`<a xlink:href="javascript:alert(3)"><circle r="1"></circle></a></svg>`,
+			want:    []string{`<svg>`, `<circle r="1">`},
+			removed: []string{`alert`},
+			found:   []string{"svg[onload]", "script", "a[xlink:href]"},
+		},
+		{
+			name: "meta refresh",
+			head: `<meta http-equiv="refresh" content="0; url=https://example.com/"/>` +
+				`<meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>`,
+			want:    []string{`http-equiv="Content-Type"`},
+			removed: []string{`refresh`},
+			found:   []string{"meta[http-equiv]"},
+		},
+		{
+			name: "library meta",
+			head: `<meta name="library-api" content="/api/v1/books/other"/>` +
+				`<meta name=" Library-Document " content="x.xhtml"/><meta name="viewport" content="width=device-width"/>`,
+			want:    []string{`name="viewport"`},
+			removed: []string{`library-api`, `Library-Document`, `/api/v1/books/other`},
+			found:   []string{"meta[name]"},
+		},
+		{
+			name:    "link other than stylesheet",
+			head:    `<link rel="stylesheet" href="style.css"/><link rel="import" href="x.html"/>`,
+			want:    []string{`href="style.css"`},
+			removed: []string{`x.html`},
+			found:   []string{"link[rel]"},
+		},
+		{
+			name:    "style that runs scripts",
+			body:    `<p style="background: url(javascript:alert(1))">One</p><p style="color: red">Two</p>`,
+			want:    []string{`<p>One</p>`, `<p style="color: red">Two</p>`},
+			removed: []string{`javascript`},
+			found:   []string{"p[style]"},
+		},
+		{
+			name:    "elements not allowed keep their content",
+			body:    `<blink><p>Text</p></blink>`,
+			want:    []string{`<p>Text</p>`},
+			removed: []string{`blink`},
+			found:   []string{"blink"},
+		},
+		{
+			name:  "untrusted keeps remote images",
+			body:  `<img src="https://tracker.example/i.png"/>`,
+			want:  []string{`src="https://tracker.example/i.png"`},
+			found: []string{},
+		},
+		{
+			name:    "strict removes remote images",
+			trust:   TrustStrict,
+			body:    `<img src="https://tracker.example/i.png"/><img src="//tracker.example/j.png"/><img src="cover.png"/>`,
+			want:    []string{`src="cover.png"`},
+			removed: []string{`tracker.example/i.png`},
+			found:   []string{"img[src]"},
+		},
+		{
+			name:  "strict removes remote stylesheets",
+			trust: TrustStrict,
+			head: `<link rel="stylesheet" href="https://example.com/x.css"/>` +
+				`<style>p { background: url(https://example.com/i.png) }</style>`,
+			removed: []string{`example.com`},
+			found:   []string{"link", "style"},
+		},
+		{
+			name:    "strict removes scripts too",
+			trust:   TrustStrict,
+			body:    `<p onclick="alert(1)">Text</p><script>alert(2)</script>`,
+			want:    []string{`<p>Text</p>`},
+			removed: []string{`alert`},
+			found:   []string{"p[onclick]", "script"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := html.Parse(strings.NewReader(
+				`<html><head>` + tt.head + `</head><body>` + tt.body + `</body></html>`,
+			))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s := newSanitiser(tt.trust)
+			s.html("test.xhtml", doc)
+
+			buf := &bytes.Buffer{}
+
+			if err := html.Render(buf, doc); err != nil {
+				t.Fatal(err)
+			}
+
+			out := buf.String()
+
+			for _, w := range tt.want {
+				if !strings.Contains(out, w) {
+					t.Errorf("sanitised document %s does not have %s", out, w)
+				}
+			}
+
+			for _, r := range tt.removed {
+				if strings.Contains(out, r) {
+					t.Errorf("sanitised document %s still has %s", out, r)
+				}
+			}
+
+			found := map[string]bool{}
+
+			for _, f := range s.found {
+				found[f.Name] = true
+			}
+
+			for _, f := range tt.found {
+				if !found[f] {
+					t.Errorf("sanitised = %+v, want %s among them", s.found, f)
+				}
+			}
+
+			if len(tt.found) == 0 && len(s.found) > 0 {
+				t.Errorf("sanitised = %+v, want nothing", s.found)
+			}
+		})
+	}
+}
+
+func TestSanitiserSVG(t *testing.T) {
+	tests := []struct {
+		name    string
+		trust   Trust
+		svg     string
+		want    []string
+		removed []string
+	}{
+		{
+			name: "scripts and handlers",
+			svg: `<svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"><script>alert(2)</script>` +
+				`<rect width="10" height="10"/></svg>`,
+			want:    []string{`<rect width="10" height="10"></rect>`},
+			removed: []string{`alert`, `<script`},
+		},
+		{
+			name: "foreign object",
+			svg: `<svg xmlns="http://www.w3.org/2000/svg"><foreignObject><div xmlns="http://www.w3.org/1999/xhtml">` +
+				`<iframe src="x.html"/></div></foreignObject><circle r="1"/></svg>`,
+			want:    []string{`<circle r="1"></circle>`},
+			removed: []string{`foreignObject`, `iframe`},
+		},
+		{
+			name: "use",
+			svg: `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">` +
+				`<use xlink:href="data:image/svg+xml;base64,AAAA"/><use href="#shape"/></svg>`,
+			want:    []string{`<use></use>`, `<use href="#shape"></use>`},
+			removed: []string{`data:image/svg+xml`},
+		},
+		{
+			name: "javascript links",
+			svg: `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">` +
+				`<a xlink:href="javascript:alert(1)"><text>Link</text></a></svg>`,
+			want:    []string{`<text>Link</text>`},
+			removed: []string{`javascript`},
+		},
+		{
+			name:    "strict removes remote images",
+			trust:   TrustStrict,
+			svg:     `<svg xmlns="http://www.w3.org/2000/svg"><image href="https://tracker.example/i.png"/></svg>`,
+			removed: []string{`tracker.example`},
+		},
+		{
+			name:    "unparseable",
+			svg:     `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script><rect width=1/></svg>`,
+			want:    []string{`<svg xmlns="http://www.w3.org/2000/svg"></svg>`},
+			removed: []string{`alert`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSanitiser(tt.trust)
+			out := string(s.svg("test.svg", strings.NewReader(tt.svg)))
+
+			for _, w := range tt.want {
+				if !strings.Contains(out, w) {
+					t.Errorf("sanitised image %s does not have %s", out, w)
+				}
+			}
+
+			for _, r := range tt.removed {
+				if strings.Contains(out, r) {
+					t.Errorf("sanitised image %s still has %s", out, r)
+				}
+			}
+
+			if len(s.found) == 0 {
+				t.Error("sanitised = [], want what was removed")
+			}
+		})
+	}
+}
+
+func TestSanitiseBook(t *testing.T) {
+	files := map[string]string{
+		"chapter.xhtml": testDocument(`<p onclick="alert(1)">Text</p><script>alert(2)</script>`),
+		"image.svg":     `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(3)</script></svg>`,
+	}
+
+	tests := []struct {
+		trust     Trust
+		sanitised bool
+	}{
+		{trust: TrustUntrusted, sanitised: true},
+		{trust: TrustStrict, sanitised: true},
+		{trust: TrustTrusted, sanitised: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.trust.String(), func(t *testing.T) {
+			b := testBook(t, files, WithTrust(tt.trust))
+
+			chapter := string(b.documents["chapter.xhtml"].Content)
+
+			if got := strings.Contains(chapter, "alert(1)") || strings.Contains(chapter, "alert(2)"); got == tt.sanitised {
+				t.Errorf("chapter has scripts = %v, want %v", got, !tt.sanitised)
+			}
+
+			_, rendered := b.documents["image.svg"]
+
+			if rendered != tt.sanitised {
+				t.Errorf("image rendered = %v, want %v", rendered, tt.sanitised)
+			}
+
+			if got := len(b.Sanitised()) > 0; got != tt.sanitised {
+				t.Errorf("Sanitised() = %+v, want something removed = %v", b.Sanitised(), tt.sanitised)
+			}
+		})
+	}
+}
+
+func TestParseTrust(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Trust
+		wantErr bool
+	}{
+		{name: "untrusted", want: TrustUntrusted},
+		{name: " Strict ", want: TrustStrict},
+		{name: "TRUSTED", want: TrustTrusted},
+		{name: "", wantErr: true},
+		{name: "everything", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTrust(tt.name)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTrust() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("ParseTrust() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
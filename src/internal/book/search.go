@@ -0,0 +1,396 @@
+package book
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"go.pkg.littleman.co/library/internal/nonce"
+	"go.pkg.littleman.co/library/internal/problems"
+	"golang.org/x/net/html"
+)
+
+const (
+	// PathSearch is where the search page of a book is served, relative to the book
+	PathSearch = "/search"
+
+	// QueryHighlight is the query parameter a document reads the terms to highlight from
+	QueryHighlight = "highlight"
+
+	searchLimit = 50
+
+	// The number of words either side of the first match that are included in a snippet
+	snippetBefore = 12
+	snippetAfter  = 24
+)
+
+// blocks are the elements whose text is indexed as a single passage
+var blocks = map[string]bool{
+	"p": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "li": true, "dt": true,
+	"dd": true, "blockquote": true, "pre": true, "td": true, "th": true, "figcaption": true, "caption": true,
+	"aside": true, "div": true, "section": true,
+}
+
+var searchTemplate = template.Must(template.New("search").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Search: {{ .Title }}</title>
+	{{ .Head }}
+</head>
+<body>
+	<h1><a href="./">{{ .Title }}</a></h1>
+	<form action="search" method="get" class="library-search">
+		<input type="search" name="q" value="{{ .Query }}" placeholder="Search this book">
+		<button type="submit">Search</button>
+	</form>
+	{{- if .Query }}
+	<p>{{ len .Hits }} result{{ if ne (len .Hits) 1 }}s{{ end }} for <em>{{ .Query }}</em></p>
+	<ol class="library-search-results">
+	{{- range .Hits }}
+		<li>
+			<a href="{{ .Href }}">{{ .Title }}</a>
+			<p>{{ .Snippet }}</p>
+		</li>
+	{{- end }}
+	</ol>
+	{{- end }}
+	{{ .Body }}
+</body>
+</html>
+`))
+
+// Hit is a passage of the book that matches a search
+type Hit struct {
+	// Title is the title of the document the passage is in
+	Title string `json:"chapter"`
+
+	// Path is the location of the document the passage is in, relative to the content root
+	Path string `json:"path"`
+
+	// Anchor is the id of the element nearest to the passage, if there is one
+	Anchor string `json:"anchor,omitempty"`
+
+	// Href links to the passage from the root of the book, highlighting the terms that matched
+	Href string `json:"href"`
+
+	// Snippet is an extract of the passage, with the matching terms marked
+	Snippet template.HTML `json:"snippet"`
+
+	// Score is the relevance of the passage to the search; higher is more relevant
+	Score float64 `json:"score"`
+}
+
+// Index is an inverted index of the text of a book
+type Index struct {
+	passages []passage
+
+	// The passages each term appears in
+	terms map[string][]posting
+}
+
+type passage struct {
+	title  string
+	path   string
+	anchor string
+	text   string
+}
+
+type posting struct {
+	passage int
+	count   int
+}
+
+func newIndex() *Index {
+	return &Index{
+		terms: map[string][]posting{},
+	}
+}
+
+// tokenize splits text into the terms that are indexed and searched for
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// add indexes each passage of the document at path
+func (i *Index) add(path string, title string, doc *html.Node) {
+	if len(title) == 0 {
+		title = path
+	}
+
+	// Passages are anchored to the nearest element with an id at or before them
+	anchor := ""
+
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type != html.ElementNode {
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				f(c)
+			}
+
+			return
+		}
+
+		switch n.Data {
+		case "head", "script", "style", "nav":
+			return
+		}
+
+		if id := attr(n, "id"); len(id) > 0 {
+			anchor = id
+		}
+
+		// Only the innermost blocks are passages, so that text is not indexed twice
+		if blocks[n.Data] && !hasBlock(n) {
+			i.addPassage(passage{title: title, path: path, anchor: anchor, text: text(n)})
+			return
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+
+	f(doc)
+}
+
+func (i *Index) addPassage(p passage) {
+	if len(p.text) == 0 {
+		return
+	}
+
+	counts := map[string]int{}
+
+	for _, t := range tokenize(p.text) {
+		counts[t]++
+	}
+
+	for t, c := range counts {
+		i.terms[t] = append(i.terms[t], posting{passage: len(i.passages), count: c})
+	}
+
+	i.passages = append(i.passages, p)
+}
+
+// Search returns the passages that contain any of the terms in query, most relevant first. Passages that contain
+// more of the terms are always more relevant.
+func (i *Index) Search(query string) []Hit {
+	terms := unique(tokenize(query))
+	scores := map[int]float64{}
+	matched := map[int]int{}
+
+	for _, t := range terms {
+		postings := i.terms[t]
+
+		if len(postings) == 0 {
+			continue
+		}
+
+		// Terms that appear in few passages say more about those passages
+		idf := math.Log(1 + float64(len(i.passages))/float64(len(postings)))
+
+		for _, p := range postings {
+			scores[p.passage] += float64(p.count) * idf
+			matched[p.passage]++
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+
+	for p, score := range scores {
+		hits = append(hits, i.hit(i.passages[p], terms, score+float64(matched[p]*len(terms))))
+	}
+
+	sort.SliceStable(hits, func(a, b int) bool {
+		if hits[a].Score != hits[b].Score {
+			return hits[a].Score > hits[b].Score
+		}
+
+		return hits[a].Href < hits[b].Href
+	})
+
+	if len(hits) > searchLimit {
+		hits = hits[:searchLimit]
+	}
+
+	return hits
+}
+
+func (i *Index) hit(p passage, terms []string, score float64) Hit {
+	highlight := url.Values{QueryHighlight: {strings.Join(terms, " ")}}.Encode()
+
+	return Hit{
+		Title:   p.title,
+		Path:    p.path,
+		Anchor:  p.anchor,
+		Href:    relative("", &url.URL{Path: p.path}) + "?" + highlight + fragment(p.anchor),
+		Snippet: snippet(p.text, terms),
+		Score:   math.Round(score*1000) / 1000,
+	}
+}
+
+// snippet returns the words surrounding the first match in text, with each matching word marked
+func snippet(text string, terms []string) template.HTML {
+	words := strings.Fields(text)
+	first := -1
+	marked := make([]bool, len(words))
+
+	for n, w := range words {
+		for _, t := range tokenize(w) {
+			for _, q := range terms {
+				if t == q {
+					marked[n] = true
+				}
+			}
+		}
+
+		if marked[n] && first < 0 {
+			first = n
+		}
+	}
+
+	start, end := first-snippetBefore, first+snippetAfter
+
+	if start < 0 {
+		start = 0
+	}
+
+	if end > len(words) {
+		end = len(words)
+	}
+
+	b := strings.Builder{}
+
+	if start > 0 {
+		b.WriteString("… ")
+	}
+
+	for n := start; n < end; n++ {
+		if n > start {
+			b.WriteString(" ")
+		}
+
+		if marked[n] {
+			b.WriteString("<mark>" + html.EscapeString(words[n]) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(words[n]))
+		}
+	}
+
+	if end < len(words) {
+		b.WriteString(" …")
+	}
+
+	return template.HTML(b.String())
+}
+
+// serveSearch renders the results of the search in the "q" parameter, as JSON if the client asked for it and as a
+// page otherwise
+func (h Book) serveSearch(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	hits := []Hit{}
+
+	if len(query) > 0 {
+		hits = h.index.Search(query)
+	}
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(struct {
+			Query string `json:"query"`
+			Hits  []Hit  `json:"hits"`
+		}{
+			Query: query,
+			Hits:  hits,
+		}); err != nil {
+			problems.Render(w, r, errors.Wrap(err, "unable to render search results"))
+		}
+
+		return
+	}
+
+	// Results are not a document of the book, so the scripts that record what readers do in one are left out
+	head, body, err := h.injection.html("", "")
+
+	if err != nil {
+		problems.Render(w, r, errors.Wrap(err, "unable to render search results"))
+		return
+	}
+
+	page := &bytes.Buffer{}
+
+	if err := searchTemplate.Execute(page, struct {
+		Title string
+		Query string
+		Hits  []Hit
+		Head  template.HTML
+		Body  template.HTML
+	}{
+		Title: h.Title(),
+		Query: query,
+		Hits:  hits,
+		Head:  head,
+		Body:  body,
+	}); err != nil {
+		problems.Render(w, r, errors.Wrap(err, "unable to render search results"))
+		return
+	}
+
+	w.Header().Set("Content-Type", pageTypeHTML)
+	w.Write(withNonce(page.Bytes(), nonce.FromRequest(r)))
+}
+
+// wantsJSON indicates whether the client would rather have JSON than HTML, either by asking for it explicitly with
+// the "format" parameter or by preferring it in the Accept header.
+func wantsJSON(r *http.Request) bool {
+	if f := r.URL.Query().Get("format"); len(f) > 0 {
+		return f == "json"
+	}
+
+	accept := r.Header.Get("Accept")
+
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// hasBlock indicates whether any descendant of n is a block that would be indexed on its own
+func hasBlock(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && (blocks[c.Data] || hasBlock(c)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func unique(terms []string) []string {
+	seen := map[string]bool{}
+	u := []string{}
+
+	for _, t := range terms {
+		if !seen[t] {
+			seen[t] = true
+			u = append(u, t)
+		}
+	}
+
+	return u
+}
+
+func fragment(anchor string) string {
+	if len(anchor) == 0 {
+		return ""
+	}
+
+	return "#" + url.PathEscape(anchor)
+}
@@ -0,0 +1,148 @@
+package book
+
+import (
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotModified is returned by a source when the book has not changed since the version that was asked about
+var ErrNotModified = errors.New("book not modified")
+
+// Source is somewhere a book is fetched from, such as a file on disk or an object in a bucket
+type Source interface {
+	// String identifies the source in logs, such as by its path or URL
+	String() string
+
+	// Name is the file name of the book, that its slug is derived from
+	Name() string
+
+	// Fetch retrieves the book to local disk, unless it has not changed since the version since, in which case it
+	// returns ErrNotModified. The zero Version has always changed.
+	Fetch(since Version) (*Fetched, error)
+}
+
+// Version identifies a version of a book at its source. Sources fill in whichever fields they can tell versions apart
+// by.
+type Version struct {
+	// ModTime is when this version was made
+	ModTime time.Time
+
+	// Size is the size of this version, in bytes
+	Size int64
+
+	// ETag is the entity tag of this version, if the source is served over HTTP
+	ETag string
+
+	// Digest is the SHA-256 of this version, if it had to be downloaded to tell it apart from others
+	Digest string
+}
+
+// Fetched is a version of a book that has been retrieved from its source
+type Fetched struct {
+	// Path is where the book is on local disk
+	Path string
+
+	// Version is the version of the book that was retrieved
+	Version Version
+
+	// Temporary indicates that Path was created by fetching the book, and should be removed when it is no longer
+	// needed
+	Temporary bool
+}
+
+// ParseSource returns the source at location; an http(s):// URL, an s3://bucket/key URL or a path on disk. Objects in
+// S3 are fetched with s3.
+func ParseSource(location string, s3 S3Config) (Source, error) {
+	u, err := url.Parse(location)
+
+	// Anything that is not a URL with a known scheme is a path
+	if err != nil {
+		return NewFileSource(location), nil
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return NewHTTPSource(location)
+	case "s3":
+		return NewS3Source(location, s3)
+	}
+
+	return NewFileSource(location), nil
+}
+
+// FileSource is a book in a file on local disk
+type FileSource struct {
+	path string
+}
+
+// NewFileSource returns the source for the book at path
+func NewFileSource(path string) *FileSource {
+	return &FileSource{path: path}
+}
+
+// String returns the path of the book
+func (s *FileSource) String() string {
+	return s.path
+}
+
+// Name returns the file name of the book
+func (s *FileSource) Name() string {
+	return filepath.Base(s.path)
+}
+
+// Fetch copies the book to a file of its own, unless its modification time and size are the same as since. Were it
+// read where it is, a book written again in place would change beneath the requests still reading the version before.
+func (s *FileSource) Fetch(since Version) (*Fetched, error) {
+	info, err := os.Stat(s.path)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch book")
+	}
+
+	v := Version{ModTime: info.ModTime(), Size: info.Size()}
+
+	if v.ModTime.Equal(since.ModTime) && v.Size == since.Size {
+		return nil, ErrNotModified
+	}
+
+	in, err := os.Open(s.path)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch book")
+	}
+
+	defer in.Close()
+
+	f, err := ioutil.TempFile("", "library-*"+extTypeEPUB)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch book")
+	}
+
+	// The size is that of the copy, so that if the book changes while it is being copied, the copy is still whole
+	// and the change is picked up the next time it is checked
+	v.Size, err = io.Copy(f, in)
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, errors.Wrapf(err, "unable to fetch book from %s", s)
+	}
+
+	return &Fetched{Path: f.Name(), Version: v, Temporary: true}, nil
+}
+
+// urlName returns the file name at the end of the path of a URL
+func urlName(u *url.URL) string {
+	return path.Base(u.Path)
+}
@@ -0,0 +1,288 @@
+package book
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+
+	// Covers may be in any of the image formats EPUB allows that can be decoded without leaving the standard library
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ThumbnailMediaType is the type of every thumbnail, whatever the type of the cover it is made from
+	ThumbnailMediaType = "image/jpeg"
+
+	// thumbnailMaxSize is the largest thumbnail that can be asked for, which is already larger than most covers
+	thumbnailMaxSize = 2048
+)
+
+// DefaultThumbnailSizes are the sizes of thumbnails made when none are configured; small enough for a list of books,
+// for a catalogue and for a preview when a book is shared.
+var DefaultThumbnailSizes = []int{128, 256, 512}
+
+// Thumbnails makes smaller copies of the covers of books, each fitting within a square of one of a few sizes, and
+// keeps them so that each is only made once for each version of a book.
+type Thumbnails struct {
+	sizes []int
+
+	// Where thumbnails are kept on disk. If empty, they are kept in memory.
+	dir string
+
+	// Thumbnails kept in memory, by slug and size. Only the latest version of each book is kept. Each is made while
+	// holding the lock for its key, so that the others can be served meanwhile.
+	keys keyLocks
+	mu   sync.Mutex
+	kept map[string]*Document
+}
+
+// NewThumbnails creates somewhere to make and keep thumbnails, at DefaultThumbnailSizes unless configured otherwise
+func NewThumbnails(options ...func(*Thumbnails) error) (*Thumbnails, error) {
+	t := &Thumbnails{
+		sizes: DefaultThumbnailSizes,
+		kept:  map[string]*Document{},
+	}
+
+	for _, o := range options {
+		if err := o(t); err != nil {
+			return nil, errors.Wrap(err, "unable to persist option to Thumbnails")
+		}
+	}
+
+	return t, nil
+}
+
+// WithThumbnailSizes sets the sizes thumbnails are made at. No others can be asked for, so that readers cannot have
+// the server make any number of them.
+func WithThumbnailSizes(sizes ...int) func(*Thumbnails) error {
+	return func(t *Thumbnails) error {
+		if len(sizes) == 0 {
+			return errors.New("no thumbnail sizes")
+		}
+
+		for _, s := range sizes {
+			if s < 1 || s > thumbnailMaxSize {
+				return errors.Errorf("invalid thumbnail size %d, must be between 1 and %d", s, thumbnailMaxSize)
+			}
+		}
+
+		t.sizes = append([]int{}, sizes...)
+		sort.Ints(t.sizes)
+
+		return nil
+	}
+}
+
+// WithThumbnailDirectory keeps thumbnails on disk in dir, rather than in memory, so that they outlast the server
+func WithThumbnailDirectory(dir string) func(*Thumbnails) error {
+	return func(t *Thumbnails) error {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return errors.Wrap(err, "unable to create thumbnail directory")
+		}
+
+		t.dir = dir
+
+		return nil
+	}
+}
+
+// Sizes returns the sizes thumbnails are made at, smallest first
+func (t *Thumbnails) Sizes() []int {
+	return t.sizes
+}
+
+// Fit returns the smallest size thumbnails are made at that is at least size, or the largest if none are
+func (t *Thumbnails) Fit(size int) int {
+	for _, s := range t.sizes {
+		if s >= size {
+			return s
+		}
+	}
+
+	return t.sizes[len(t.sizes)-1]
+}
+
+// Has indicates whether thumbnails are made at size
+func (t *Thumbnails) Has(size int) bool {
+	for _, s := range t.sizes {
+		if s == size {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Thumbnailable indicates whether thumbnails can be made of the cover of b. Those that cannot, such as SVG covers, are
+// best shown as they are.
+func Thumbnailable(b *Book) bool {
+	if b.Cover == nil {
+		return false
+	}
+
+	switch b.Cover.MediaType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+
+	return false
+}
+
+// Get returns the thumbnail of the cover of b at size, making it if it has not been made for this version of the book
+func (t *Thumbnails) Get(b *Book, size int) (*Document, error) {
+	if !t.Has(size) {
+		return nil, errors.Errorf("unable to make thumbnail: %d is not a thumbnail size", size)
+	}
+
+	if !Thumbnailable(b) {
+		return nil, errors.Errorf("unable to make thumbnail of %s: no cover it can be made from", b.Slug)
+	}
+
+	f := b.entry(b.Cover.Path)
+
+	if f == nil {
+		return nil, errors.Errorf("unable to make thumbnail of %s: cover %s is not in book", b.Slug, b.Cover.Path)
+	}
+
+	// The checksum and size of the cover identify it without having to read it
+	version := fmt.Sprintf("%08x-%x", f.CRC32, f.UncompressedSize64)
+	etag := fmt.Sprintf("%q", fmt.Sprintf("%s-%d", version, size))
+	key := fmt.Sprintf("%s-%d", b.Slug, size)
+
+	unlock := t.keys.lock(key)
+	defer unlock()
+
+	t.mu.Lock()
+	d, ok := t.kept[key]
+	t.mu.Unlock()
+
+	if ok && d.ETag == etag {
+		return d, nil
+	}
+
+	path := filepath.Join(t.dir, fmt.Sprintf("%s-%s-%d.jpg", b.Slug, version, size))
+
+	if len(t.dir) > 0 {
+		if content, err := ioutil.ReadFile(path); err == nil {
+			return &Document{Content: content, ETag: etag}, nil
+		}
+	}
+
+	content, err := thumbnail(b, size)
+
+	if err != nil {
+		return nil, err
+	}
+
+	d = &Document{Content: content, ETag: etag}
+
+	if len(t.dir) == 0 {
+		t.mu.Lock()
+		t.kept[key] = d
+		t.mu.Unlock()
+
+		return d, nil
+	}
+
+	if err := t.keep(b.Slug, size, path, content); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// keep writes the thumbnail of the book addressed by slug at size to path, then removes those of earlier versions of
+// the book. It is written elsewhere and moved into place, so that nothing can read it half written.
+func (t *Thumbnails) keep(slug string, size int, path string, content []byte) error {
+	f, err := ioutil.TempFile(t.dir, fmt.Sprintf(".%s-%d-*.jpg", slug, size))
+
+	if err != nil {
+		return errors.Wrapf(err, "unable to keep thumbnail of %s", slug)
+	}
+
+	_, err = f.Write(content)
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return errors.Wrapf(err, "unable to keep thumbnail of %s", slug)
+	}
+
+	// Thumbnails of earlier versions of the book are no longer needed. Those of books whose slugs begin the same way
+	// match the pattern too, so each is checked for being of this book.
+	old, err := filepath.Glob(filepath.Join(t.dir, fmt.Sprintf("%s-*-%d.jpg", slug, size)))
+
+	if err != nil {
+		return nil
+	}
+
+	version := regexp.MustCompile(fmt.Sprintf(`^%s-[0-9a-f]{8}-[0-9a-f]+-%d\.jpg$`, regexp.QuoteMeta(slug), size))
+
+	for _, o := range old {
+		if o == path || !version.MatchString(filepath.Base(o)) {
+			continue
+		}
+
+		if err := os.Remove(o); err != nil {
+			log.Printf("unable to remove earlier thumbnail of %s: %s", slug, err)
+		}
+	}
+
+	return nil
+}
+
+// thumbnail makes a thumbnail of the cover of b that fits within a square of size. Anything transparent is shown
+// against white, as JPEG cannot be transparent.
+func thumbnail(b *Book, size int) ([]byte, error) {
+	decodes <- struct{}{}
+	defer func() { <-decodes }()
+
+	img, _, err := b.decode(b.Cover.Path)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to make thumbnail of %s", b.Slug)
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(1, (bounds.Dy()*size+bounds.Dx()/2)/bounds.Dx())
+		} else {
+			width, height = max(1, (bounds.Dx()*size+bounds.Dy()/2)/bounds.Dy()), size
+		}
+	}
+
+	scaled := scale(img, width, height)
+	flat := image.NewRGBA(scaled.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), scaled, image.Point{}, draw.Over)
+
+	buf := &bytes.Buffer{}
+
+	if err := jpeg.Encode(buf, flat, &jpeg.Options{Quality: imageQuality}); err != nil {
+		return nil, errors.Wrapf(err, "unable to make thumbnail of %s", b.Slug)
+	}
+
+	return buf.Bytes(), nil
+}
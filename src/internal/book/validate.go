@@ -0,0 +1,466 @@
+package book
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/kapmahc/epub"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+const (
+	// SeverityError marks an issue that stops the book being read as intended
+	SeverityError = "error"
+
+	// SeverityWarning marks an issue that readers are unlikely to notice, but that other tools may reject
+	SeverityWarning = "warning"
+
+	pathMimetype  = "mimetype"
+	pathContainer = "META-INF/container.xml"
+	dirMetaInf    = "META-INF/"
+)
+
+// Issue is a structural problem with a book
+type Issue struct {
+	// Severity is how much the issue matters; SeverityError or SeverityWarning
+	Severity string `json:"severity"`
+
+	// Code identifies the kind of issue, such as "missing-resource"
+	Code string `json:"code"`
+
+	// Path is the file within the book the issue was found in, if any
+	Path string `json:"path,omitempty"`
+
+	// Message describes the issue
+	Message string `json:"message"`
+}
+
+// Report is what validating a book found
+type Report struct {
+	// Path is the location of the book that was validated
+	Path string `json:"path"`
+
+	// Valid indicates that there are no issues of SeverityError
+	Valid bool `json:"valid"`
+
+	Errors   int `json:"errors"`
+	Warnings int `json:"warnings"`
+
+	Issues []Issue `json:"issues"`
+}
+
+// validator accumulates the issues found in a book
+type validator struct {
+	report *Report
+
+	// The names of every file in the archive, and the files by name so that each can be opened without searching
+	files   map[string]bool
+	entries map[string]*zip.File
+
+	// The directory that the package document is in, and so what manifest references are relative to
+	root string
+
+	// The IDs in each XHTML document, by name within the archive, so that links to fragments can be checked
+	ids map[string]map[string]bool
+}
+
+// Validate checks the structure of the book at path; that it has a container and package document, that its manifest
+// and spine refer to files that exist and list every file there is, that it has a table of contents, and that its XHTML
+// documents are well formed and link only to things in the book. It returns an error only if the book cannot be read at
+// all.
+func Validate(path string) (*Report, error) {
+	r, err := zip.OpenReader(path)
+
+	v := &validator{
+		report:  &Report{Path: path, Issues: []Issue{}},
+		files:   map[string]bool{},
+		entries: map[string]*zip.File{},
+		ids:     map[string]map[string]bool{},
+	}
+
+	if err != nil {
+		if errors.Cause(err) != zip.ErrFormat {
+			return nil, errors.Wrapf(err, "unable to validate %s", path)
+		}
+
+		v.error("invalid-archive", "", "the book is not a ZIP archive")
+
+		return v.finish(), nil
+	}
+
+	defer r.Close()
+
+	for _, f := range r.File {
+		v.files[f.Name] = true
+
+		// Where a name appears more than once, the first is what readers read
+		if _, ok := v.entries[f.Name]; !ok {
+			v.entries[f.Name] = f
+		}
+	}
+
+	v.validate()
+
+	return v.finish(), nil
+}
+
+func (v *validator) validate() {
+	v.mimetype()
+
+	container := epub.Container{}
+
+	if !v.files[pathContainer] {
+		v.error("missing-container", pathContainer, "the book has no container, so its package document cannot be found")
+		return
+	}
+
+	if err := v.decode(pathContainer, &container); err != nil {
+		v.error("invalid-container", pathContainer, err.Error())
+		return
+	}
+
+	opf := container.Rootfile.Path
+
+	if len(opf) == 0 {
+		v.error("missing-package", pathContainer, "the container declares no package document")
+		return
+	}
+
+	if !v.files[opf] {
+		v.error("missing-package", pathContainer, fmt.Sprintf("the package document %s is not in the book", opf))
+		return
+	}
+
+	if v.root = path.Dir(opf); v.root == "." {
+		v.root = ""
+	}
+
+	pkg := epub.Opf{}
+
+	if err := v.decode(opf, &pkg); err != nil {
+		v.error("invalid-package", opf, err.Error())
+		return
+	}
+
+	manifest := v.manifest(opf, pkg)
+	v.spine(opf, pkg, manifest)
+	ncx := v.navigation(opf, pkg, manifest)
+
+	// Documents are checked for well-formedness first, so that every ID is known before links to them are checked
+	documents := []string{}
+
+	for _, m := range pkg.Manifest {
+		if name, ok := manifest[m.ID]; ok && m.MediaType == mediaTypeXHTML && v.files[name] {
+			documents = append(documents, name)
+			v.xhtml(name)
+		}
+	}
+
+	for _, name := range documents {
+		v.links(name)
+	}
+
+	// The NCX is only checked if it is in the book; if it is not, that has been reported already
+	if len(ncx) > 0 && v.files[ncx] {
+		v.ncx(ncx)
+	}
+}
+
+// mimetype checks that the book declares itself to be an EPUB
+func (v *validator) mimetype() {
+	if !v.files[pathMimetype] {
+		v.warning("missing-mimetype", "", "the book has no mimetype file")
+		return
+	}
+
+	content, err := v.read(pathMimetype)
+
+	if err != nil {
+		v.error("invalid-mimetype", pathMimetype, err.Error())
+		return
+	}
+
+	if strings.TrimSpace(string(content)) != mediaTypeEPUB {
+		v.warning("invalid-mimetype", pathMimetype, fmt.Sprintf("the mimetype is %q, not %q", content, mediaTypeEPUB))
+	}
+}
+
+// manifest checks that every file the manifest lists is in the book and every file in the book is listed, returning
+// the name of each listed file within the archive, by manifest ID
+func (v *validator) manifest(opf string, pkg epub.Opf) map[string]string {
+	names := map[string]string{}
+	listed := map[string]bool{}
+
+	for _, m := range pkg.Manifest {
+		u, err := resolve("", m.Href)
+
+		if err != nil {
+			v.error("invalid-href", opf, fmt.Sprintf("manifest item %s has an invalid href %q", m.ID, m.Href))
+			continue
+		}
+
+		// Resources outside the book, such as fonts on the web, are not checked
+		if u.IsAbs() || len(u.Host) > 0 {
+			continue
+		}
+
+		name := path.Join(v.root, u.Path)
+		names[m.ID] = name
+		listed[name] = true
+
+		if !v.files[name] {
+			v.error("missing-resource", opf, fmt.Sprintf("manifest item %s refers to %s, which is not in the book", m.ID, name))
+		}
+	}
+
+	for _, f := range v.sorted() {
+		if f == pathMimetype || f == opf || strings.HasPrefix(f, dirMetaInf) || strings.HasSuffix(f, "/") || listed[f] {
+			continue
+		}
+
+		v.warning("unlisted-resource", f, "the file is in the book, but not in the manifest")
+	}
+
+	return names
+}
+
+// spine checks that there is a reading order, and that everything in it is in the manifest
+func (v *validator) spine(opf string, pkg epub.Opf, manifest map[string]string) {
+	if len(pkg.Spine.Items) == 0 {
+		v.error("empty-spine", opf, "the spine is empty, so the book has no reading order")
+	}
+
+	for _, i := range pkg.Spine.Items {
+		if _, ok := manifest[i.IDref]; !ok {
+			v.error("missing-spine-item", opf, fmt.Sprintf("the spine refers to %q, which is not in the manifest", i.IDref))
+		}
+	}
+}
+
+// navigation checks that there is a table of contents; the EPUB 3 navigation document or the EPUB 2 NCX, returning
+// the name of the NCX if there is one. The links of the navigation document are checked with those of every other
+// document.
+func (v *validator) navigation(opf string, pkg epub.Opf, manifest map[string]string) string {
+	found := false
+	ncx := ""
+
+	for _, m := range pkg.Manifest {
+		if hasProperty(m.Properties, propertyNav) {
+			found = true
+		}
+	}
+
+	if len(pkg.Spine.Toc) > 0 {
+		name, ok := manifest[pkg.Spine.Toc]
+
+		if !ok {
+			v.error("missing-navigation", opf, fmt.Sprintf("the spine refers to an NCX %q, which is not in the manifest", pkg.Spine.Toc))
+		} else if v.files[name] {
+			found = true
+			ncx = name
+		}
+	}
+
+	if !found {
+		v.error("missing-navigation", opf, "the book has neither a navigation document nor an NCX")
+	}
+
+	return ncx
+}
+
+// ncx checks that the NCX is well formed, and links only to things in the book
+func (v *validator) ncx(name string) {
+	ncx := epub.Ncx{}
+
+	if err := v.decode(name, &ncx); err != nil {
+		v.error("invalid-ncx", name, err.Error())
+		return
+	}
+
+	var check func(points []epub.NavPoint)
+
+	check = func(points []epub.NavPoint) {
+		for _, p := range points {
+			v.link(name, p.Content.Src)
+			check(p.Points)
+		}
+	}
+
+	check(ncx.Points)
+}
+
+// xhtml checks that a document is well formed XML, and records the IDs within it
+func (v *validator) xhtml(name string) {
+	f, err := v.open(name)
+
+	if err != nil {
+		v.error("invalid-xhtml", name, err.Error())
+		return
+	}
+
+	defer f.Close()
+
+	ids := map[string]bool{}
+	v.ids[name] = ids
+
+	d := xml.NewDecoder(f)
+
+	// Readers know the entities of HTML, such as &nbsp;, though XML alone does not
+	d.Entity = xml.HTMLEntity
+
+	for {
+		t, err := d.Token()
+
+		if err == io.EOF {
+			return
+		}
+
+		if err != nil {
+			v.error("invalid-xhtml", name, err.Error())
+			return
+		}
+
+		if e, ok := t.(xml.StartElement); ok {
+			for _, a := range e.Attr {
+				if a.Name.Local == "id" {
+					ids[a.Value] = true
+				}
+			}
+		}
+	}
+}
+
+// links checks that every link and embedded resource in a document that refers to something in the book exists
+func (v *validator) links(name string) {
+	f, err := v.open(name)
+
+	if err != nil {
+		return
+	}
+
+	defer f.Close()
+
+	doc, err := html.Parse(f)
+
+	if err != nil {
+		return
+	}
+
+	var walk func(n *html.Node)
+
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			for _, a := range n.Attr {
+				if a.Key == "href" || a.Key == "src" {
+					v.link(name, a.Val)
+				}
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+
+	walk(doc)
+}
+
+// link checks that ref, found in the file at from, refers to a file in the book and, if it is to a fragment of an XHTML
+// document, to an ID within it
+func (v *validator) link(from string, ref string) {
+	u, err := resolve(from, ref)
+
+	if err != nil {
+		v.error("broken-link", from, fmt.Sprintf("%q is not a valid reference", ref))
+		return
+	}
+
+	// Links to the web, to email addresses and the like are out of scope
+	if len(u.Scheme) > 0 || len(u.Host) > 0 {
+		return
+	}
+
+	if !v.files[u.Path] {
+		v.error("broken-link", from, fmt.Sprintf("%q refers to %s, which is not in the book", ref, u.Path))
+		return
+	}
+
+	if ids, ok := v.ids[u.Path]; ok && len(u.Fragment) > 0 && !ids[u.Fragment] {
+		v.error("broken-link", from, fmt.Sprintf("%q refers to #%s, which is not in %s", ref, u.Fragment, u.Path))
+	}
+}
+
+// decode parses the XML file at name into into
+func (v *validator) decode(name string, into interface{}) error {
+	f, err := v.open(name)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	return errors.Wrapf(xml.NewDecoder(f).Decode(into), "unable to parse %s", name)
+}
+
+func (v *validator) read(name string) ([]byte, error) {
+	f, err := v.open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+
+	return content, errors.Wrapf(err, "unable to read %s", name)
+}
+
+func (v *validator) open(name string) (io.ReadCloser, error) {
+	f, ok := v.entries[name]
+
+	if !ok {
+		return nil, errors.Errorf("unable to read %s: not in book", name)
+	}
+
+	r, err := f.Open()
+
+	return r, errors.Wrapf(err, "unable to read %s", name)
+}
+
+// sorted returns the name of every file in the archive, in order
+func (v *validator) sorted() []string {
+	names := make([]string, 0, len(v.files))
+
+	for f := range v.files {
+		names = append(names, f)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func (v *validator) error(code string, path string, message string) {
+	v.report.Errors++
+	v.report.Issues = append(v.report.Issues, Issue{Severity: SeverityError, Code: code, Path: path, Message: message})
+}
+
+func (v *validator) warning(code string, path string, message string) {
+	v.report.Warnings++
+	v.report.Issues = append(v.report.Issues, Issue{Severity: SeverityWarning, Code: code, Path: path, Message: message})
+}
+
+func (v *validator) finish() *Report {
+	v.report.Valid = v.report.Errors == 0
+
+	return v.report
+}
@@ -0,0 +1,42 @@
+package book
+
+import (
+	"testing"
+)
+
+func TestValidateXHTML(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		invalid bool
+	}{
+		{name: "well formed", body: `<p>Text</p>`},
+		{name: "XML entities", body: `<p>Fish &amp; chips &lt;3</p>`},
+		{name: "HTML entities", body: `<p>A&nbsp;space &mdash; and a dash&hellip;</p>`},
+		{name: "unknown entity", body: `<p>&notanentity;</p>`, invalid: true},
+		{name: "unclosed element", body: `<p>Text`, invalid: true},
+		{name: "mismatched element", body: `<p>Text</div>`, invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := Validate(testEPUB(t, map[string]string{"chapter.xhtml": testDocument(tt.body)}))
+
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+
+			got := false
+
+			for _, i := range report.Issues {
+				if i.Code == "invalid-xhtml" {
+					got = true
+				}
+			}
+
+			if got != tt.invalid {
+				t.Errorf("invalid-xhtml reported = %v, want %v; issues %+v", got, tt.invalid, report.Issues)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package nonce
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+type contextKey int
+
+// contextKeyNonce is where the nonce of a request is stored in the request context
+const contextKeyNonce contextKey = iota
+
+// New returns a nonce that cannot be guessed, for a single response
+func New() (string, error) {
+	b := make([]byte, 18)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "unable to generate nonce")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// WithRequest returns a copy of the request, carrying the nonce that scripts in the response must have to run
+func WithRequest(r *http.Request, nonce string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), contextKeyNonce, nonce))
+}
+
+// FromRequest returns the nonce that scripts in the response to a request must have to run. It is empty if scripts
+// need no nonce.
+func FromRequest(r *http.Request) string {
+	n, _ := r.Context().Value(contextKeyNonce).(string)
+
+	return n
+}
@@ -0,0 +1,126 @@
+package problems
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ContentTypeProblemJSON is the media type of a problem serialised as JSON
+//
+// See https://tools.ietf.org/html/rfc7807#section-6.1
+const ContentTypeProblemJSON = "application/problem+json"
+
+// TypeBlank is the type of a problem that has no further semantics than its status code
+const TypeBlank = "about:blank"
+
+var problemTemplate = template.Must(template.New("problem").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>{{ .Title }}</title>
+</head>
+<body>
+	<h1>{{ .Title }}</h1>
+	{{- with .Detail }}
+	<p>{{ . }}</p>
+	{{- end }}
+	{{- if ne .Type "about:blank" }}
+	<p><a href="{{ .Type }}">More information about this problem</a></p>
+	{{- end }}
+</body>
+</html>
+`))
+
+// document is the representation of a problem sent to clients
+type document struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Detail   string `json:"detail,omitempty"`
+	Status   int    `json:"status"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// Render writes err to the client as a problem, as HTML for browsers and as application/problem+json otherwise.
+//
+// Only problems intended for the audience of the response are described to them. Anything else, including errors that
+// are not problems at all, is logged and replaced by a description of the status code alone.
+func Render(w http.ResponseWriter, r *http.Request, err error) {
+	p := internal(err)
+
+	if c, ok := errors.Cause(err).(*Problem); ok {
+		p = *c
+	} else if c, ok := errors.Cause(err).(Problem); ok {
+		p = c
+	}
+
+	if p.Status == 0 {
+		p.Status = http.StatusInternalServerError
+	}
+
+	audience := AudienceAPIUser
+	if prefersHTML(r) {
+		audience = AudienceConsumer
+	}
+
+	doc := document{
+		Type:     p.Type,
+		Title:    p.Title,
+		Detail:   p.Description,
+		Status:   p.Status,
+		Instance: r.RequestURI,
+	}
+
+	if !p.IsFor(audience) {
+		log.Printf("%s %s: %s", r.Method, r.URL, err)
+
+		doc.Type = TypeBlank
+		doc.Title = http.StatusText(p.Status)
+		doc.Detail = ""
+	}
+
+	// Problems are particular to the request that caused them
+	w.Header().Set("Cache-Control", "no-store")
+
+	if audience == AudienceConsumer {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(doc.Status)
+		problemTemplate.Execute(w, doc)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentTypeProblemJSON)
+	w.WriteHeader(doc.Status)
+	json.NewEncoder(w).Encode(doc)
+}
+
+// internal describes an error that was not expected, and is only of interest to developers
+func internal(err error) Problem {
+	return Problem{
+		Type:        TypeBlank,
+		Title:       http.StatusText(http.StatusInternalServerError),
+		Description: err.Error(),
+		Status:      http.StatusInternalServerError,
+		Audience:    []int{AudienceDeveloper},
+	}
+}
+
+// prefersHTML indicates whether the client is a browser, which asks for HTML ahead of anything else
+func prefersHTML(r *http.Request) bool {
+	for _, t := range strings.Split(r.Header.Get("Accept"), ",") {
+		switch strings.TrimSpace(strings.SplitN(t, ";", 2)[0]) {
+		case "text/html", "application/xhtml+xml":
+			return true
+		case ContentTypeProblemJSON, "application/json":
+			return false
+		}
+	}
+
+	return false
+}
@@ -41,6 +41,9 @@ type Problem struct {
 
 	// The users who are the intended target of this problem message
 	Audience []int
+
+	// The HTTP status code that best describes this problem, if it is returned over HTTP
+	Status int
 }
 
 // Factory allows easy, opinionated problem creation
@@ -74,6 +77,13 @@ func (p Problem) IsFor(Audience int) bool {
 	return false
 }
 
+// WithStatus sets the HTTP status code that is returned alongside this problem
+func (p *Problem) WithStatus(Status int) *Problem {
+	p.Status = Status
+
+	return p
+}
+
 // New Creates a new problem object
 func New(Type string, Title, Message string, Audience []int) *Problem {
 	return &Problem{
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"go.pkg.littleman.co/library/internal/book"
+	"go.pkg.littleman.co/library/internal/problems"
+	"go.pkg.littleman.co/library/internal/server/middleware"
+	"go.pkg.littleman.co/library/internal/store"
+)
+
+// maxNoteLength is the longest note, in bytes, that can be attached to an annotation
+const maxNoteLength = 1 << 13
+
+// Annotations returns a handler that lists (GET) and creates (POST) the authenticated reader's annotations in the book
+// addressed by the "slug" route variable. Listing may be limited to a single document with the "source" parameter.
+func Annotations(l *book.Library, s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subject, ok := middleware.Subject(r)
+
+		if !ok {
+			problems.Render(w, r, problemSignInRequired())
+			return
+		}
+
+		b, ok := l.Book(mux.Vars(r)["slug"])
+
+		if !ok {
+			problems.Render(w, r, problemBookNotFound())
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			all, err := s.Annotations(subject, b.Slug)
+
+			if err != nil {
+				problems.Render(w, r, err)
+				return
+			}
+
+			source := r.URL.Query().Get("source")
+			annotations := []*store.Annotation{}
+
+			for _, a := range all {
+				if len(source) == 0 || a.Target.Source == source {
+					annotations = append(annotations, a)
+				}
+			}
+
+			writeJSON(w, http.StatusOK, annotations)
+
+			return
+		}
+
+		a := &store.Annotation{}
+
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(a); err != nil {
+			problems.Render(w, r, problemInvalidAnnotation(errors.Wrap(err, "unable to read body").Error()))
+			return
+		}
+
+		if err := validateAnnotation(b, a); err != nil {
+			problems.Render(w, r, problemInvalidAnnotation(err.Error()))
+			return
+		}
+
+		a.Book = b.Slug
+
+		if err := s.AddAnnotation(subject, a); err != nil {
+			problems.Render(w, r, err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, a)
+	}
+}
+
+// Annotation returns a handler that deletes the authenticated reader's annotation addressed by the "slug" and "id"
+// route variables
+func Annotation(l *book.Library, s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subject, ok := middleware.Subject(r)
+
+		if !ok {
+			problems.Render(w, r, problemSignInRequired())
+			return
+		}
+
+		b, ok := l.Book(mux.Vars(r)["slug"])
+
+		if !ok {
+			problems.Render(w, r, problemBookNotFound())
+			return
+		}
+
+		found, err := s.DeleteAnnotation(subject, b.Slug, mux.Vars(r)["id"])
+
+		if err != nil {
+			problems.Render(w, r, err)
+			return
+		}
+
+		if !found {
+			problems.Render(w, r, problemAnnotationNotFound())
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// validateAnnotation checks that an annotation refers to somewhere in the book, in a way that can be found again
+func validateAnnotation(b *book.Book, a *store.Annotation) error {
+	if _, ok := b.Chapter(a.Target.Source); !ok {
+		return errors.New("target source is not a document in the reading order of the book")
+	}
+
+	switch a.Motivation {
+	case store.MotivationBookmarking, store.MotivationHighlighting:
+	case store.MotivationCommenting:
+		if len(a.BodyValue) == 0 {
+			return errors.New("comments must have a body value")
+		}
+	default:
+		return errors.Errorf("motivation must be one of %s, %s or %s", store.MotivationBookmarking,
+			store.MotivationHighlighting, store.MotivationCommenting)
+	}
+
+	if len(a.BodyValue) > maxNoteLength {
+		return errors.Errorf("body value must be no longer than %d bytes", maxNoteLength)
+	}
+
+	// Bookmarks may refer to a whole document, but anything else is about some particular text
+	if len(a.Target.Selector) == 0 && a.Motivation != store.MotivationBookmarking {
+		return errors.New("target must have a selector")
+	}
+
+	return validateSelectors(b, a.Target)
+}
+
+// validateSelectors checks that each selector of target describes something that can be found in its source
+func validateSelectors(b *book.Book, target store.Target) error {
+	for _, s := range target.Selector {
+		switch s.Type {
+		case store.SelectorTextQuote:
+			if len(s.Exact) == 0 {
+				return errors.New("text quote selectors must have an exact quote")
+			}
+		case store.SelectorTextPosition:
+			if s.Start == nil || s.End == nil || *s.Start < 0 || *s.End <= *s.Start {
+				return errors.New("text position selectors must have a start before their end")
+			}
+		case store.SelectorFragment:
+			if !b.HasAnchor(target.Source, s.Value) {
+				return errors.Errorf("fragment selector value %q is not the id of an element in the target source", s.Value)
+			}
+		default:
+			return errors.Errorf("selector type must be one of %s, %s or %s", store.SelectorTextQuote,
+				store.SelectorTextPosition, store.SelectorFragment)
+		}
+	}
+
+	return nil
+}
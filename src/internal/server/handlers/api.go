@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.pkg.littleman.co/library/internal/book"
+	"go.pkg.littleman.co/library/internal/problems"
+)
+
+// PrefixAPI is the path under which the API is mounted
+const PrefixAPI = "/api/v1"
+
+type apiBook struct {
+	// ID is the identifier the book is addressed by in the API
+	ID string `json:"id"`
+
+	// Href is where the book can be read
+	Href string `json:"href"`
+
+	book.Metadata
+}
+
+type apiBookDetail struct {
+	apiBook
+
+	Spine []book.SpineItem `json:"spine"`
+}
+
+type apiContents struct {
+	ID       string          `json:"id"`
+	Contents []book.Contents `json:"contents"`
+}
+
+// APIBooks returns a handler that lists the metadata of every book in the library
+func APIBooks(l *book.Library) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		books := []apiBook{}
+
+		for _, b := range l.Books() {
+			books = append(books, newAPIBook(b))
+		}
+
+		writeJSON(w, http.StatusOK, books)
+	}
+}
+
+// APIBook returns a handler that describes the book addressed by the "slug" route variable
+func APIBook(l *book.Library) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, ok := l.Book(mux.Vars(r)["slug"])
+
+		if !ok {
+			problems.Render(w, r, problemBookNotFound())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, apiBookDetail{
+			apiBook: newAPIBook(b),
+			Spine:   b.Spine,
+		})
+	}
+}
+
+// APIContents returns a handler that lists the table of contents of the book addressed by the "slug" route variable
+func APIContents(l *book.Library) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, ok := l.Book(mux.Vars(r)["slug"])
+
+		if !ok {
+			problems.Render(w, r, problemBookNotFound())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, apiContents{
+			ID:       b.Slug,
+			Contents: b.Contents,
+		})
+	}
+}
+
+func newAPIBook(b *book.Book) apiBook {
+	return apiBook{
+		ID:       b.Slug,
+		Href:     fmt.Sprintf("%s/%s/", PrefixBooks, b.Slug),
+		Metadata: b.Metadata,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.pkg.littleman.co/library/internal/book"
+	"go.pkg.littleman.co/library/internal/problems"
+)
+
+// PrefixBooks is the path under which each book in the library is mounted
+const PrefixBooks = "/books"
+
+// Book returns a handler that serves the book addressed by the "slug" route variable, relative to the prefix it is
+// mounted under
+func Book(l *book.Library) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		slug := mux.Vars(r)["slug"]
+
+		b, ok := l.Acquire(slug)
+
+		if !ok {
+			problems.Render(w, r, problemBookNotFound())
+			return
+		}
+
+		defer b.Release()
+
+		prefix := fmt.Sprintf("%s/%s", PrefixBooks, slug)
+
+		// Books are only ever served as a directory, so that relative links within them resolve.
+		if r.URL.Path == prefix {
+			http.Redirect(w, r, prefix+"/", http.StatusMovedPermanently)
+			return
+		}
+
+		http.StripPrefix(prefix, http.HandlerFunc(b.Handler)).ServeHTTP(w, r)
+	}
+}
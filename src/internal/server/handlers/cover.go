@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"go.pkg.littleman.co/library/internal/book"
+	"go.pkg.littleman.co/library/internal/problems"
+)
+
+// PrefixCovers is the path under which the cover of each book, and its thumbnails, can be found
+const PrefixCovers = "/covers"
+
+const (
+	// thumbnailSizeIndex and thumbnailSizeCatalogue are the sizes of thumbnail wanted in the index and the catalogue.
+	// The nearest size made that is at least as large is used.
+	thumbnailSizeIndex     = 128
+	thumbnailSizeCatalogue = 256
+)
+
+// Cover returns a handler that serves the cover of the book addressed by the "slug" route variable, as it is in the
+// book
+func Cover(l *book.Library) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, ok := l.Acquire(mux.Vars(r)["slug"])
+
+		if !ok {
+			problems.Render(w, r, problemBookNotFound())
+			return
+		}
+
+		defer b.Release()
+
+		if b.Cover == nil {
+			problems.Render(w, r, problemCoverNotFound())
+			return
+		}
+
+		b.ServeCover(w, r)
+	}
+}
+
+// Thumbnail returns a handler that serves a thumbnail of the cover of the book addressed by the "slug" route variable,
+// at the size addressed by the "size" route variable. Covers that thumbnails cannot be made of are served as they
+// are.
+func Thumbnail(l *book.Library, t *book.Thumbnails) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, ok := l.Acquire(mux.Vars(r)["slug"])
+
+		if !ok {
+			problems.Render(w, r, problemBookNotFound())
+			return
+		}
+
+		defer b.Release()
+
+		size, err := strconv.Atoi(mux.Vars(r)["size"])
+
+		if err != nil || !t.Has(size) || b.Cover == nil {
+			problems.Render(w, r, problemCoverNotFound())
+			return
+		}
+
+		if !book.Thumbnailable(b) {
+			b.ServeCover(w, r)
+			return
+		}
+
+		d, err := t.Get(b, size)
+
+		if err != nil {
+			log.Printf("%s, serving the cover instead", err)
+			b.ServeCover(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", book.ThumbnailMediaType)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", d.ETag)
+
+		http.ServeContent(w, r, "", b.ModTime, bytes.NewReader(d.Content))
+	}
+}
+
+// coverLink returns where the cover of the book is
+func coverLink(b *book.Book) string {
+	return fmt.Sprintf("%s/%s", PrefixCovers, b.Slug)
+}
+
+// thumbnailLink returns where the thumbnail of the cover of the book at size is, and its type. Covers that thumbnails
+// cannot be made of are their own thumbnails.
+func thumbnailLink(b *book.Book, size int) opdsLink {
+	if !book.Thumbnailable(b) {
+		return opdsLink{Href: coverLink(b), Type: b.Cover.MediaType}
+	}
+
+	return opdsLink{Href: fmt.Sprintf("%s/%d", coverLink(b), size), Type: book.ThumbnailMediaType}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/gorilla/mux"
+	"go.pkg.littleman.co/library/internal/book"
+	"go.pkg.littleman.co/library/internal/problems"
+)
+
+// PrefixDownloads is the path under which the EPUB file of each book can be downloaded
+const PrefixDownloads = "/downloads"
+
+// Download returns a handler that serves the EPUB file of the book addressed by the "slug" route variable, as it is on
+// disk, unless downloads of that book are disabled
+func Download(l *book.Library) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, ok := l.Acquire(mux.Vars(r)["slug"])
+
+		if !ok {
+			problems.Render(w, r, problemBookNotFound())
+			return
+		}
+
+		defer b.Release()
+
+		if !b.Downloadable() {
+			problems.Render(w, r, problemDownloadDisabled())
+			return
+		}
+
+		w.Header().Set("Content-Type", ContentTypeEPUB)
+		w.Header().Set("Content-Disposition", attachment(b.Filename()))
+		w.Header().Set("Cache-Control", "no-cache")
+
+		http.ServeContent(w, r, "", b.ModTime, b.Original())
+	}
+}
+
+// attachment offers a response to be saved as a file called name. Clients that do not understand the UTF-8 form of the
+// name (RFC 6266) fall back to an ASCII approximation of it.
+func attachment(name string) string {
+	ascii := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || unicode.IsControl(r) || r == '"' || r == '\\' {
+			return '_'
+		}
+
+		return r
+	}, name)
+
+	encoded := strings.Builder{}
+
+	for _, c := range []byte(name) {
+		if c < unicode.MaxASCII && (unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)) || strings.IndexByte("!#$&+-.^_`|~", c) >= 0) {
+			encoded.WriteByte(c)
+			continue
+		}
+
+		fmt.Fprintf(&encoded, "%%%02X", c)
+	}
+
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, ascii, encoded.String())
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"go.pkg.littleman.co/library/internal/book"
+	"go.pkg.littleman.co/library/internal/problems"
+	"go.pkg.littleman.co/library/internal/server/middleware"
+	"go.pkg.littleman.co/library/internal/store"
+)
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>Library</title>
+	<link rel="alternate" type="{{ .OPDSAtom.Type }}" href="{{ .OPDSAtom.Href }}" title="OPDS catalogue">
+	<link rel="alternate" type="{{ .OPDSJSON.Type }}" href="{{ .OPDSJSON.Href }}" title="OPDS catalogue">
+	<style type="text/css">
+body {
+	display: block;
+	margin: 0 auto;
+	max-width: 1200px;
+	padding: 0 15px !important;
+}
+
+.book-cover {
+	max-height: 64px;
+	max-width: 64px;
+	vertical-align: middle;
+}
+	</style>
+</head>
+<body>
+	<h1>Library</h1>
+	<ul>
+	{{- range .Books }}
+		<li>
+			{{- with index $.Thumbnails .Slug }}
+			<img class="book-cover" src="{{ . }}" alt="" loading="lazy">
+			{{- end }}
+			<a href="{{ $.Prefix }}/{{ .Slug }}/">{{ .Title }}</a>
+			{{- with index $.Progress .Slug }}
+			(<a href="{{ $.Prefix }}/{{ .Book }}/{{ .Path }}">continue reading</a>)
+			{{- end }}
+		</li>
+	{{- end }}
+	</ul>
+</body>
+</html>
+`))
+
+// Index returns a handler that lists all books in the library with their covers, offering readers to continue from
+// wherever they were in each if there is a store to remember that in.
+func Index(l *book.Library, s *store.Store, t *book.Thumbnails) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		progress := map[string]*store.Progress{}
+
+		if subject, ok := middleware.Subject(r); ok && s != nil {
+			all, err := s.AllProgress(subject)
+
+			if err != nil {
+				problems.Render(w, r, err)
+				return
+			}
+
+			progress = all
+		}
+
+		books := l.Books()
+		thumbnails := map[string]string{}
+
+		for _, b := range books {
+			if b.Cover != nil {
+				thumbnails[b.Slug] = thumbnailLink(b, t.Fit(thumbnailSizeIndex)).Href
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		if err := indexTemplate.Execute(w, struct {
+			Prefix     string
+			Books      []*book.Book
+			Progress   map[string]*store.Progress
+			Thumbnails map[string]string
+			OPDSAtom   opdsLink
+			OPDSJSON   opdsLink
+		}{
+			Prefix:     PrefixBooks,
+			Books:      books,
+			Progress:   progress,
+			Thumbnails: thumbnails,
+			OPDSAtom:   opdsLink{Href: PrefixOPDS + PathOPDSAtom, Type: ContentTypeOPDSAtom},
+			OPDSJSON:   opdsLink{Href: PrefixOPDS + PathOPDSJSON, Type: ContentTypeOPDSJSON},
+		}); err != nil {
+			problems.Render(w, r, errors.Wrap(err, "unable to render index"))
+		}
+	}
+}
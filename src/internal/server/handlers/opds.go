@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.pkg.littleman.co/library/internal/book"
+)
+
+const (
+	// PrefixOPDS is the path under which the OPDS catalogues of the library are served
+	PrefixOPDS = "/opds"
+
+	// PathOPDSAtom is where the OPDS 1.2 catalogue is served, relative to PrefixOPDS
+	PathOPDSAtom = "/catalog.xml"
+
+	// PathOPDSJSON is where the OPDS 2.0 catalogue is served, relative to PrefixOPDS
+	PathOPDSJSON = "/catalog.json"
+
+	// ContentTypeOPDSAtom is the media type of an OPDS 1.2 acquisition feed
+	ContentTypeOPDSAtom = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+
+	// ContentTypeOPDSJSON is the media type of an OPDS 2.0 feed
+	ContentTypeOPDSJSON = "application/opds+json"
+
+	// ContentTypeEPUB is the media type of an EPUB file
+	ContentTypeEPUB = "application/epub+zip"
+
+	relAcquisition = "http://opds-spec.org/acquisition"
+	relImage       = "http://opds-spec.org/image"
+	relThumbnail   = "http://opds-spec.org/image/thumbnail"
+
+	catalogTitle = "Library"
+)
+
+// atomFeed is an OPDS 1.2 acquisition feed
+//
+// See https://specs.opds.io/opds-1.2
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	DC      string      `xml:"xmlns:dc,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID          string         `xml:"id"`
+	Title       string         `xml:"title"`
+	Authors     []atomAuthor   `xml:"author"`
+	Updated     string         `xml:"updated"`
+	Languages   []string       `xml:"dc:language"`
+	Identifiers []string       `xml:"dc:identifier"`
+	Publisher   string         `xml:"dc:publisher,omitempty"`
+	Summary     string         `xml:"summary,omitempty"`
+	Rights      string         `xml:"rights,omitempty"`
+	Categories  []atomCategory `xml:"category"`
+	Links       []atomLink     `xml:"link"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// opdsFeed is an OPDS 2.0 feed
+//
+// See https://drafts.opds.io/opds-2.0
+type opdsFeed struct {
+	Metadata     opdsFeedMetadata  `json:"metadata"`
+	Links        []opdsLink        `json:"links"`
+	Publications []opdsPublication `json:"publications"`
+}
+
+type opdsFeedMetadata struct {
+	Title         string `json:"title"`
+	Modified      string `json:"modified"`
+	NumberOfItems int    `json:"numberOfItems"`
+}
+
+type opdsPublication struct {
+	Metadata opdsMetadata `json:"metadata"`
+	Links    []opdsLink   `json:"links"`
+	Images   []opdsLink   `json:"images,omitempty"`
+}
+
+type opdsMetadata struct {
+	Type        string        `json:"@type"`
+	Identifier  string        `json:"identifier"`
+	Title       string        `json:"title"`
+	Author      []opdsContrib `json:"author,omitempty"`
+	Language    []string      `json:"language,omitempty"`
+	Publisher   string        `json:"publisher,omitempty"`
+	Modified    string        `json:"modified"`
+	Description string        `json:"description,omitempty"`
+	Subject     []opdsSubject `json:"subject,omitempty"`
+}
+
+type opdsContrib struct {
+	Name   string `json:"name"`
+	SortAs string `json:"sortAs,omitempty"`
+}
+
+type opdsSubject struct {
+	Name string `json:"name"`
+}
+
+type opdsLink struct {
+	Rel  string `json:"rel,omitempty"`
+	Href string `json:"href"`
+	Type string `json:"type"`
+}
+
+// OPDSAtom returns a handler that describes every book in the library as an OPDS 1.2 acquisition feed
+func OPDSAtom(l *book.Library, t *book.Thumbnails) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		books := l.Books()
+		self := PrefixOPDS + PathOPDSAtom
+
+		feed := atomFeed{
+			DC:      "http://purl.org/dc/terms/",
+			ID:      "urn:library:catalog",
+			Title:   catalogTitle,
+			Updated: updated(books).Format(time.RFC3339),
+			Links: []atomLink{
+				{Rel: "self", Href: self, Type: ContentTypeOPDSAtom},
+				{Rel: "start", Href: self, Type: ContentTypeOPDSAtom},
+			},
+			Entries: []atomEntry{},
+		}
+
+		for _, b := range books {
+			e := atomEntry{
+				ID:        publicationID(b),
+				Title:     b.Title(),
+				Updated:   b.ModTime.UTC().Format(time.RFC3339),
+				Languages: b.Metadata.Language,
+				Publisher: b.Metadata.Publisher,
+				Summary:   b.Metadata.Description,
+				Rights:    b.Metadata.Rights,
+			}
+
+			for _, c := range b.Metadata.Creators {
+				e.Authors = append(e.Authors, atomAuthor{Name: c.Name})
+			}
+
+			for _, i := range b.Metadata.Identifiers {
+				e.Identifiers = append(e.Identifiers, i.Value)
+			}
+
+			for _, s := range b.Metadata.Subjects {
+				e.Categories = append(e.Categories, atomCategory{Term: s, Label: s})
+			}
+
+			for _, link := range publicationLinks(b, t) {
+				e.Links = append(e.Links, atomLink(link))
+			}
+
+			feed.Entries = append(feed.Entries, e)
+		}
+
+		w.Header().Set("Content-Type", ContentTypeOPDSAtom)
+		w.WriteHeader(http.StatusOK)
+
+		w.Write([]byte(xml.Header))
+		xml.NewEncoder(w).Encode(feed)
+	}
+}
+
+// OPDSJSON returns a handler that describes every book in the library as an OPDS 2.0 feed
+func OPDSJSON(l *book.Library, t *book.Thumbnails) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		books := l.Books()
+
+		feed := opdsFeed{
+			Metadata: opdsFeedMetadata{
+				Title:         catalogTitle,
+				Modified:      updated(books).Format(time.RFC3339),
+				NumberOfItems: len(books),
+			},
+			Links: []opdsLink{
+				{Rel: "self", Href: PrefixOPDS + PathOPDSJSON, Type: ContentTypeOPDSJSON},
+				{Rel: "alternate", Href: PrefixOPDS + PathOPDSAtom, Type: ContentTypeOPDSAtom},
+			},
+			Publications: []opdsPublication{},
+		}
+
+		for _, b := range books {
+			p := opdsPublication{
+				Metadata: opdsMetadata{
+					Type:        "http://schema.org/Book",
+					Identifier:  publicationID(b),
+					Title:       b.Title(),
+					Language:    b.Metadata.Language,
+					Publisher:   b.Metadata.Publisher,
+					Modified:    b.ModTime.UTC().Format(time.RFC3339),
+					Description: b.Metadata.Description,
+				},
+				Links: []opdsLink{},
+			}
+
+			for _, c := range b.Metadata.Creators {
+				p.Metadata.Author = append(p.Metadata.Author, opdsContrib{Name: c.Name, SortAs: c.FileAs})
+			}
+
+			for _, s := range b.Metadata.Subjects {
+				p.Metadata.Subject = append(p.Metadata.Subject, opdsSubject{Name: s})
+			}
+
+			// Images are listed apart from the other links in OPDS 2.0
+			for _, link := range publicationLinks(b, t) {
+				switch link.Rel {
+				case relImage, relThumbnail:
+					p.Images = append(p.Images, opdsLink{Href: link.Href, Type: link.Type})
+				default:
+					p.Links = append(p.Links, link)
+				}
+			}
+
+			feed.Publications = append(feed.Publications, p)
+		}
+
+		w.Header().Set("Content-Type", ContentTypeOPDSJSON)
+		w.WriteHeader(http.StatusOK)
+
+		json.NewEncoder(w).Encode(feed)
+	}
+}
+
+// publicationLinks returns where the book can be downloaded, read and what its cover looks like
+func publicationLinks(b *book.Book, t *book.Thumbnails) []opdsLink {
+	links := []opdsLink{
+		{Rel: "alternate", Href: fmt.Sprintf("%s/%s/", PrefixBooks, b.Slug), Type: "text/html"},
+	}
+
+	if b.Downloadable() {
+		links = append(links, opdsLink{
+			Rel:  relAcquisition,
+			Href: fmt.Sprintf("%s/%s.epub", PrefixDownloads, b.Slug),
+			Type: ContentTypeEPUB,
+		})
+	}
+
+	if b.Cover != nil {
+		thumbnail := thumbnailLink(b, t.Fit(thumbnailSizeCatalogue))
+		thumbnail.Rel = relThumbnail
+
+		links = append(links, opdsLink{Rel: relImage, Href: coverLink(b), Type: b.Cover.MediaType}, thumbnail)
+	}
+
+	return links
+}
+
+// publicationID returns a URI that identifies the book, preferring the one it declares for itself
+func publicationID(b *book.Book) string {
+	for _, i := range b.Metadata.Identifiers {
+		if strings.Contains(i.Value, ":") {
+			return i.Value
+		}
+	}
+
+	return "urn:library:" + b.Slug
+}
+
+// updated returns when the most recently changed book changed
+func updated(books []*book.Book) time.Time {
+	latest := time.Time{}
+
+	for _, b := range books {
+		if b.ModTime.After(latest) {
+			latest = b.ModTime
+		}
+	}
+
+	return latest.UTC()
+}
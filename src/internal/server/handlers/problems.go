@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"net/http"
+
+	"go.pkg.littleman.co/library/internal/problems"
+)
+
+var problem = &problems.Factory{
+	URITemplate: "https://github.com/littlemanco/library/tree/master/docs/errors/__ID__.md",
+}
+
+// problemBookNotFound is returned when a request is for a book that is not in the library
+func problemBookNotFound() *problems.Problem {
+	return problem.WithEverything(
+		"Book Not Found",
+		"There is no book in the library at this address.",
+		[]int{problems.AudienceConsumer, problems.AudienceAPIUser},
+	).WithStatus(http.StatusNotFound)
+}
+
+// problemCoverNotFound is returned when a request is for a cover, or a size of thumbnail of one, that does not exist
+func problemCoverNotFound() *problems.Problem {
+	return problem.WithEverything(
+		"Cover Not Found",
+		"The book has no cover, or no thumbnail of it at this size.",
+		[]int{problems.AudienceConsumer, problems.AudienceAPIUser},
+	).WithStatus(http.StatusNotFound)
+}
+
+// problemSignInRequired is returned when a request is for something that only makes sense for a known reader
+func problemSignInRequired() *problems.Problem {
+	return problem.WithEverything(
+		"Sign In Required",
+		"Only readers who have signed in can do this.",
+		[]int{problems.AudienceConsumer, problems.AudienceAPIUser},
+	).WithStatus(http.StatusUnauthorized)
+}
+
+// problemNotStarted is returned when a reader asks where they are in a book they have not started
+func problemNotStarted() *problems.Problem {
+	return problem.WithEverything(
+		"Book Not Started",
+		"There is no record of reading this book.",
+		[]int{problems.AudienceConsumer, problems.AudienceAPIUser},
+	).WithStatus(http.StatusNotFound)
+}
+
+// problemInvalidProgress is returned when the reading progress supplied does not describe a place in the book
+func problemInvalidProgress(detail string) *problems.Problem {
+	return problem.WithEverything(
+		"Invalid Reading Progress",
+		detail,
+		[]int{problems.AudienceAPIUser},
+	).WithStatus(http.StatusBadRequest)
+}
+
+// problemInvalidAnnotation is returned when an annotation does not describe something in the book
+func problemInvalidAnnotation(detail string) *problems.Problem {
+	return problem.WithEverything(
+		"Invalid Annotation",
+		detail,
+		[]int{problems.AudienceAPIUser},
+	).WithStatus(http.StatusBadRequest)
+}
+
+// problemAnnotationNotFound is returned when a request is for an annotation the reader does not have
+func problemAnnotationNotFound() *problems.Problem {
+	return problem.WithEverything(
+		"Annotation Not Found",
+		"There is no annotation with this ID in the book.",
+		[]int{problems.AudienceConsumer, problems.AudienceAPIUser},
+	).WithStatus(http.StatusNotFound)
+}
+
+// problemInvalidComment is returned when a comment, or a change to a thread, cannot be made
+func problemInvalidComment(detail string) *problems.Problem {
+	return problem.WithEverything(
+		"Invalid Comment",
+		detail,
+		[]int{problems.AudienceAPIUser},
+	).WithStatus(http.StatusBadRequest)
+}
+
+// problemThreadNotFound is returned when a request is for a thread that is not in the book
+func problemThreadNotFound() *problems.Problem {
+	return problem.WithEverything(
+		"Thread Not Found",
+		"There is no comment thread with this ID in the book.",
+		[]int{problems.AudienceConsumer, problems.AudienceAPIUser},
+	).WithStatus(http.StatusNotFound)
+}
+
+// problemDownloadDisabled is returned when a reader asks for the EPUB file of a book that is not to leave the library
+func problemDownloadDisabled() *problems.Problem {
+	return problem.WithEverything(
+		"Download Disabled",
+		"This book can be read here, but not downloaded.",
+		[]int{problems.AudienceConsumer, problems.AudienceAPIUser},
+	).WithStatus(http.StatusForbidden)
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"go.pkg.littleman.co/library/internal/book"
+	"go.pkg.littleman.co/library/internal/problems"
+	"go.pkg.littleman.co/library/internal/server/middleware"
+	"go.pkg.littleman.co/library/internal/store"
+)
+
+// maxRequestSize is the most that will be read from the body of a request to the API
+const maxRequestSize = 1 << 16
+
+// Progress returns a handler that reads (GET) and records (PUT) how far the authenticated reader is through the book
+// addressed by the "slug" route variable
+func Progress(l *book.Library, s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subject, ok := middleware.Subject(r)
+
+		if !ok {
+			problems.Render(w, r, problemSignInRequired())
+			return
+		}
+
+		b, ok := l.Book(mux.Vars(r)["slug"])
+
+		if !ok {
+			problems.Render(w, r, problemBookNotFound())
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			p, err := s.Progress(subject, b.Slug)
+
+			if err != nil {
+				problems.Render(w, r, err)
+				return
+			}
+
+			if p == nil {
+				problems.Render(w, r, problemNotStarted())
+				return
+			}
+
+			writeJSON(w, http.StatusOK, p)
+
+			return
+		}
+
+		p := &store.Progress{}
+
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(p); err != nil {
+			problems.Render(w, r, problemInvalidProgress(errors.Wrap(err, "unable to read body").Error()))
+			return
+		}
+
+		if _, ok := b.Chapter(p.Path); !ok {
+			problems.Render(w, r, problemInvalidProgress("path is not a document in the reading order of the book"))
+			return
+		}
+
+		if p.Position < 0 || p.Position > 1 {
+			problems.Render(w, r, problemInvalidProgress("position must be between 0 and 1"))
+			return
+		}
+
+		p.Book = b.Slug
+
+		if err := s.SetProgress(subject, p); err != nil {
+			problems.Render(w, r, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, p)
+	}
+}
+
+// AllProgress returns a handler that lists how far the authenticated reader is through every book they have started
+func AllProgress(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subject, ok := middleware.Subject(r)
+
+		if !ok {
+			problems.Render(w, r, problemSignInRequired())
+			return
+		}
+
+		all, err := s.AllProgress(subject)
+
+		if err != nil {
+			problems.Render(w, r, err)
+			return
+		}
+
+		progress := []*store.Progress{}
+
+		for _, p := range all {
+			progress = append(progress, p)
+		}
+
+		// Most recently read first
+		sort.Slice(progress, func(i, j int) bool {
+			return progress[i].Updated.After(progress[j].Updated)
+		})
+
+		writeJSON(w, http.StatusOK, progress)
+	}
+}
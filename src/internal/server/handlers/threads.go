@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"go.pkg.littleman.co/library/internal/book"
+	"go.pkg.littleman.co/library/internal/problems"
+	"go.pkg.littleman.co/library/internal/server/middleware"
+	"go.pkg.littleman.co/library/internal/store"
+)
+
+// maxCommentLength is the longest comment, in bytes, that can be added to a thread
+const maxCommentLength = 1 << 13
+
+// ContentTypeMarkdown is the media type of a review exported as Markdown
+const ContentTypeMarkdown = "text/markdown; charset=utf-8"
+
+// newThread is what a reviewer sends to start a thread
+type newThread struct {
+	Target store.Target `json:"target"`
+	Text   string       `json:"text"`
+}
+
+// newComment is what a reviewer sends to reply to a thread
+type newComment struct {
+	Text string `json:"text"`
+}
+
+// threadState is what a reviewer sends to resolve or reopen a thread
+type threadState struct {
+	Resolved *bool `json:"resolved"`
+}
+
+// review is every thread in a book, grouped by chapter in reading order
+type review struct {
+	ID       string          `json:"id"`
+	Title    string          `json:"title"`
+	Chapters []reviewChapter `json:"chapters"`
+}
+
+type reviewChapter struct {
+	Source  string          `json:"source"`
+	Title   string          `json:"title"`
+	Threads []*store.Thread `json:"threads"`
+}
+
+var reviewTemplate = template.Must(template.New("review").Funcs(template.FuncMap{
+	"quote":  quote,
+	"author": authorName,
+	"indent": func(s string) string {
+		return strings.Replace(strings.TrimSpace(s), "\n", "\n  ", -1)
+	},
+}).Parse(`# Review of {{ .Title }}
+{{ range .Chapters }}
+## {{ .Title }}
+{{ range .Threads }}
+### {{ quote .Target }}{{ if .Resolved }} (resolved){{ end }}
+{{ range .Comments }}
+- **{{ author .Author }}**, {{ .Created.Format "2006-01-02 15:04" }}: {{ indent .Text }}
+{{- end }}
+{{ end }}{{ end }}`))
+
+// Threads returns a handler that lists (GET) and starts (POST) the review threads in the book addressed by the "slug"
+// route variable. Listing may be limited to a single document with the "source" parameter.
+func Threads(l *book.Library, s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a, ok := author(r)
+
+		if !ok {
+			problems.Render(w, r, problemSignInRequired())
+			return
+		}
+
+		b, ok := l.Book(mux.Vars(r)["slug"])
+
+		if !ok {
+			problems.Render(w, r, problemBookNotFound())
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			all, err := s.Threads(b.Slug)
+
+			if err != nil {
+				problems.Render(w, r, err)
+				return
+			}
+
+			source := r.URL.Query().Get("source")
+			threads := []*store.Thread{}
+
+			for _, t := range all {
+				if len(source) == 0 || t.Target.Source == source {
+					threads = append(threads, t)
+				}
+			}
+
+			writeJSON(w, http.StatusOK, threads)
+
+			return
+		}
+
+		n := &newThread{}
+
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(n); err != nil {
+			problems.Render(w, r, problemInvalidComment(errors.Wrap(err, "unable to read body").Error()))
+			return
+		}
+
+		if _, ok := b.Chapter(n.Target.Source); !ok {
+			problems.Render(w, r, problemInvalidComment("target source is not a document in the reading order of the book"))
+			return
+		}
+
+		if len(n.Target.Selector) == 0 {
+			problems.Render(w, r, problemInvalidComment("target must have a selector"))
+			return
+		}
+
+		if err := validateSelectors(b, n.Target); err != nil {
+			problems.Render(w, r, problemInvalidComment(err.Error()))
+			return
+		}
+
+		if err := validateComment(n.Text); err != nil {
+			problems.Render(w, r, problemInvalidComment(err.Error()))
+			return
+		}
+
+		t := &store.Thread{
+			Book:   b.Slug,
+			Target: n.Target,
+		}
+
+		if err := s.AddThread(t, store.Comment{Author: a, Text: n.Text}); err != nil {
+			problems.Render(w, r, err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, t)
+	}
+}
+
+// Thread returns a handler that resolves or reopens (PATCH) the thread addressed by the "slug" and "id" route
+// variables
+func Thread(l *book.Library, s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := author(r); !ok {
+			problems.Render(w, r, problemSignInRequired())
+			return
+		}
+
+		b, ok := l.Book(mux.Vars(r)["slug"])
+
+		if !ok {
+			problems.Render(w, r, problemBookNotFound())
+			return
+		}
+
+		state := &threadState{}
+
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(state); err != nil {
+			problems.Render(w, r, problemInvalidComment(errors.Wrap(err, "unable to read body").Error()))
+			return
+		}
+
+		if state.Resolved == nil {
+			problems.Render(w, r, problemInvalidComment("resolved must be true or false"))
+			return
+		}
+
+		t, err := s.SetResolved(b.Slug, mux.Vars(r)["id"], *state.Resolved)
+
+		if err != nil {
+			problems.Render(w, r, err)
+			return
+		}
+
+		if t == nil {
+			problems.Render(w, r, problemThreadNotFound())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, t)
+	}
+}
+
+// ThreadComments returns a handler that replies (POST) to the thread addressed by the "slug" and "id" route variables
+func ThreadComments(l *book.Library, s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a, ok := author(r)
+
+		if !ok {
+			problems.Render(w, r, problemSignInRequired())
+			return
+		}
+
+		b, ok := l.Book(mux.Vars(r)["slug"])
+
+		if !ok {
+			problems.Render(w, r, problemBookNotFound())
+			return
+		}
+
+		n := &newComment{}
+
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(n); err != nil {
+			problems.Render(w, r, problemInvalidComment(errors.Wrap(err, "unable to read body").Error()))
+			return
+		}
+
+		if err := validateComment(n.Text); err != nil {
+			problems.Render(w, r, problemInvalidComment(err.Error()))
+			return
+		}
+
+		t, err := s.AddComment(b.Slug, mux.Vars(r)["id"], store.Comment{Author: a, Text: n.Text})
+
+		if err != nil {
+			problems.Render(w, r, err)
+			return
+		}
+
+		if t == nil {
+			problems.Render(w, r, problemThreadNotFound())
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, t)
+	}
+}
+
+// ThreadsExport returns a handler that exports every thread in the book addressed by the "slug" route variable,
+// grouped by chapter, so the authors can work through them. It is JSON unless the "format" parameter is "markdown".
+func ThreadsExport(l *book.Library, s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := author(r); !ok {
+			problems.Render(w, r, problemSignInRequired())
+			return
+		}
+
+		b, ok := l.Book(mux.Vars(r)["slug"])
+
+		if !ok {
+			problems.Render(w, r, problemBookNotFound())
+			return
+		}
+
+		threads, err := s.Threads(b.Slug)
+
+		if err != nil {
+			problems.Render(w, r, err)
+			return
+		}
+
+		bySource := map[string][]*store.Thread{}
+
+		for _, t := range threads {
+			bySource[t.Target.Source] = append(bySource[t.Target.Source], t)
+		}
+
+		rv := review{ID: b.Slug, Title: b.Title(), Chapters: []reviewChapter{}}
+
+		for _, i := range b.Spine {
+			if len(bySource[i.Path]) == 0 {
+				continue
+			}
+
+			rv.Chapters = append(rv.Chapters, reviewChapter{
+				Source:  i.Path,
+				Title:   b.ChapterTitle(i.Path),
+				Threads: bySource[i.Path],
+			})
+		}
+
+		if r.URL.Query().Get("format") != "markdown" {
+			writeJSON(w, http.StatusOK, rv)
+			return
+		}
+
+		w.Header().Set("Content-Type", ContentTypeMarkdown)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", b.Slug+"-review.md"))
+
+		reviewTemplate.Execute(w, rv)
+	}
+}
+
+// author describes the reader that made the request from the claims of their sign in, if they are authenticated
+func author(r *http.Request) (store.Author, bool) {
+	subject, ok := middleware.Subject(r)
+
+	if !ok {
+		return store.Author{}, false
+	}
+
+	claims, _ := middleware.Claims(r)
+
+	a := store.Author{Subject: subject}
+	a.Name, _ = claims["name"].(string)
+	a.Email, _ = claims["email"].(string)
+
+	return a, true
+}
+
+// authorName is how an author is referred to in an exported review
+func authorName(a store.Author) string {
+	switch {
+	case len(a.Name) > 0:
+		return a.Name
+	case len(a.Email) > 0:
+		return a.Email
+	default:
+		return a.Subject
+	}
+}
+
+// quote is how the paragraph a thread is about is referred to in an exported review
+func quote(t store.Target) string {
+	for _, s := range t.Selector {
+		if s.Type == store.SelectorTextQuote {
+			return fmt.Sprintf("“%s”", strings.Join(strings.Fields(s.Exact), " "))
+		}
+	}
+
+	for _, s := range t.Selector {
+		if s.Type == store.SelectorFragment {
+			return "#" + s.Value
+		}
+	}
+
+	return t.Source
+}
+
+// validateComment checks that a comment says something, but not too much
+func validateComment(text string) error {
+	if len(strings.TrimSpace(text)) == 0 {
+		return errors.New("text must not be empty")
+	}
+
+	if len(text) > maxCommentLength {
+		return errors.Errorf("text must be no longer than %d bytes", maxCommentLength)
+	}
+
+	return nil
+}
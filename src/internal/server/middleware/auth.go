@@ -2,7 +2,7 @@ package middleware
 
 import (
 	"context"
-	"fmt"
+	"log"
 	"net/http"
 	"net/url"
 	"time"
@@ -19,6 +19,14 @@ const CookieNameReturnURL = "return-url"
 // CookieAuthentication the authentication token that users will be verified against
 const CookieAuthentication = "authentication"
 
+// ClaimSubject is the claim that uniquely identifies a user with the OIDC provider
+const ClaimSubject = "sub"
+
+type contextKey int
+
+// contextKeyClaims is where the claims of the authenticated user are stored in the request context
+const contextKeyClaims contextKey = iota
+
 // OIDCClaimSet is a set of claims that must match collectively for the autentication to continue
 type OIDCClaimSet map[string]string
 
@@ -34,6 +42,9 @@ type OidcAuth struct {
 	RedirectURL  *url.URL
 	Claims       []OIDCClaimSet
 
+	// Credentials authenticate clients that cannot sign in with the OIDC provider, if set
+	Credentials *Credentials
+
 	// State is a random
 	// State        string
 	// Todo: Inject telemetry
@@ -136,11 +147,23 @@ func (o *OidcAuth) Middleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// Clients that send credentials are authenticated by them alone
+		if o.Credentials != nil && o.Credentials.present(r) {
+			o.Credentials.serve(w, r, next)
+			return
+		}
+
 		// If the user is not authenticated, redirect them to the place they need to go for auth.
 		token, err := r.Cookie(CookieAuthentication)
 
 		// if there is no authentication cookie, Redirect the user to the place to login
 		if err != nil {
+			// Unless they are a client that cannot follow the redirect, in which case ask for credentials
+			if o.Credentials != nil && o.Credentials.challenges(r) {
+				o.Credentials.challenge(w, r, problemCredentialsRequired())
+				return
+			}
+
 			// Store the previous URL so users can be sent back once they're authenticated
 			writeToURL(w, r)
 			http.Redirect(w, r, o.OAuth2.AuthCodeURL("TODO"), http.StatusFound)
@@ -148,7 +171,9 @@ func (o *OidcAuth) Middleware(next http.Handler) http.Handler {
 			return
 		}
 
-		if err := o.verify(token.Value); err != nil {
+		claims, err := o.verify(token.Value)
+
+		if err != nil {
 			http.SetCookie(
 				w,
 				&http.Cookie{
@@ -158,11 +183,11 @@ func (o *OidcAuth) Middleware(next http.Handler) http.Handler {
 				},
 			)
 
-			http.Error(w, fmt.Sprintf("Unauthorized: %s. Refresh to sign in again.", err.Error()), http.StatusUnauthorized)
+			problems.Render(w, r, err)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKeyClaims, claims)))
 	})
 }
 
@@ -170,19 +195,22 @@ func (o *OidcAuth) Middleware(next http.Handler) http.Handler {
 func (o *OidcAuth) CallbackHandler(w http.ResponseWriter, r *http.Request) {
 	// Todo: Verify State
 	if r.URL.Query().Get("state") != "TODO" {
-		http.Error(w, "state did not match", http.StatusBadRequest)
+		problems.Render(w, r, problemStateMismatch())
 		return
 	}
 
 	oauth2Token, err := o.OAuth2.Exchange(context.Background(), r.URL.Query().Get("code"))
 	if err != nil {
-		http.Error(w, "Failed to exchange token: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("unable to exchange token: %s", err)
+		problems.Render(w, r, problemSignInIncomplete())
 		return
 	}
 
 	rawIDToken, ok := oauth2Token.Extra("id_token").(string)
 	if !ok {
-		http.Error(w, "Failed to get token from return: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("unable to exchange token: no id_token in response")
+		problems.Render(w, r, problemSignInIncomplete())
+		return
 	}
 
 	// Store the token in a cookie
@@ -198,7 +226,8 @@ func (o *OidcAuth) CallbackHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, readAndClearToURL(w, r), http.StatusFound)
 }
 
-func (o *OidcAuth) verify(token string) error {
+// verify checks the token was issued to this client and matches one of the claim sets, returning its claims if so
+func (o *OidcAuth) verify(token string) (map[string]interface{}, error) {
 	claims := map[string]interface{}{}
 
 	verifier := o.OIDCProvider.Verifier(&oidc.Config{ClientID: o.OAuth2.ClientID})
@@ -206,11 +235,13 @@ func (o *OidcAuth) verify(token string) error {
 	t, err := verifier.Verify(context.Background(), token)
 
 	if err != nil {
-		return errors.Wrap(err, "unable to verify user")
+		log.Printf("unable to verify user: %s", err)
+		return nil, problemUnverified()
 	}
 
 	if err := t.Claims(&claims); err != nil {
-		return errors.Wrap(err, "unable to verify user")
+		log.Printf("unable to verify user: %s", err)
+		return nil, problemUnverified()
 	}
 
 	// Unwrap list
@@ -235,9 +266,60 @@ func (o *OidcAuth) verify(token string) error {
 
 		// Only a single match needs to be valid. If it is, exit with success.
 		if isValidForClaimSet {
-			return nil
+			return claims, nil
 		}
 	}
 
-	return problem.WithTitleAudience("User Missing Valid Claim Set", []int{problems.AudienceConsumer})
+	return nil, problem.WithTitleAudience(
+		"User Missing Valid Claim Set",
+		[]int{problems.AudienceConsumer, problems.AudienceAPIUser},
+	).WithStatus(http.StatusForbidden)
+}
+
+// problemUnverified is returned when the authentication token cannot be verified, whether because it has expired,
+// been tampered with or was issued by someone else
+func problemUnverified() *problems.Problem {
+	return problem.WithEverything(
+		"Unable to Verify Sign In",
+		"Your sign in could not be verified, or has expired. Refresh to sign in again.",
+		[]int{problems.AudienceConsumer, problems.AudienceAPIUser},
+	).WithStatus(http.StatusUnauthorized)
+}
+
+// problemStateMismatch is returned when a sign in callback does not match a sign in started by this server
+func problemStateMismatch() *problems.Problem {
+	return problem.WithEverything(
+		"Sign In Not Started Here",
+		"The sign in was not started by this site. Return to the page you were reading to sign in again.",
+		[]int{problems.AudienceConsumer, problems.AudienceAPIUser},
+	).WithStatus(http.StatusBadRequest)
+}
+
+// problemSignInIncomplete is returned when the identity provider does not complete a sign in
+func problemSignInIncomplete() *problems.Problem {
+	return problem.WithEverything(
+		"Unable to Complete Sign In",
+		"The identity provider did not complete the sign in. Return to the page you were reading to try again.",
+		[]int{problems.AudienceConsumer, problems.AudienceAPIUser},
+	).WithStatus(http.StatusBadGateway)
+}
+
+// Claims returns the claims of the user that made the request, if they are authenticated
+func Claims(r *http.Request) (map[string]interface{}, bool) {
+	claims, ok := r.Context().Value(contextKeyClaims).(map[string]interface{})
+
+	return claims, ok
+}
+
+// Subject returns the identifier of the user that made the request, if they are authenticated
+func Subject(r *http.Request) (string, bool) {
+	claims, ok := Claims(r)
+
+	if !ok {
+		return "", false
+	}
+
+	sub, ok := claims[ClaimSubject].(string)
+
+	return sub, ok && len(sub) > 0
 }
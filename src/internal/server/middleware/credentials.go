@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"go.pkg.littleman.co/library/internal/problems"
+)
+
+// SubjectPrefixCredentials starts the subject of every reader authenticated by credentials, so that they cannot be
+// mistaken for a reader authenticated by the OIDC provider
+const SubjectPrefixCredentials = "credentials:"
+
+// Credentials authenticates readers by HTTP Basic authentication or a bearer token, for clients such as e-reader apps
+// that cannot follow the redirects of an OIDC sign in.
+type Credentials struct {
+	// Users are the passwords of each user that may sign in with HTTP Basic authentication, by user name
+	Users map[string]string
+
+	// Tokens are the names of the holders of each token that may be sent as a bearer token, by token. A token may also
+	// be sent as the password of HTTP Basic authentication, with any user name.
+	Tokens map[string]string
+
+	// ChallengePaths are the paths under which clients that send no credentials are asked for them, rather than being
+	// sent to sign in elsewhere
+	ChallengePaths []string
+}
+
+// Middleware requires every request to be authenticated by credentials. It is used when there is no other way to sign
+// in.
+func (c *Credentials) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.present(r) {
+			c.challenge(w, r, problemCredentialsRequired())
+			return
+		}
+
+		c.serve(w, r, next)
+	})
+}
+
+// serve authenticates a request that carries credentials, continuing to next if they are valid
+func (c *Credentials) serve(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	name, ok := c.authenticate(r)
+
+	if !ok {
+		c.challenge(w, r, problemInvalidCredentials())
+		return
+	}
+
+	claims := map[string]interface{}{
+		ClaimSubject: SubjectPrefixCredentials + name,
+		"name":       name,
+	}
+
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKeyClaims, claims)))
+}
+
+// present indicates whether a request carries credentials, whether or not they are valid
+func (c *Credentials) present(r *http.Request) bool {
+	h := r.Header.Get("Authorization")
+
+	return strings.HasPrefix(h, "Basic ") || strings.HasPrefix(h, "Bearer ")
+}
+
+// challenges indicates whether a request without credentials should be asked for them
+func (c *Credentials) challenges(r *http.Request) bool {
+	for _, p := range c.ChallengePaths {
+		if r.URL.Path == p || strings.HasPrefix(r.URL.Path, strings.TrimSuffix(p, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authenticate returns the name of whoever the credentials of a request belong to, if they are valid
+func (c *Credentials) authenticate(r *http.Request) (string, bool) {
+	if user, password, ok := r.BasicAuth(); ok {
+		if expected, ok := c.Users[user]; ok && equal(password, expected) {
+			return user, true
+		}
+
+		return c.token(password)
+	}
+
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return c.token(strings.TrimSpace(strings.TrimPrefix(h, "Bearer ")))
+	}
+
+	return "", false
+}
+
+// token returns the name of the holder of token, if it is valid
+func (c *Credentials) token(token string) (string, bool) {
+	for t, name := range c.Tokens {
+		if equal(token, t) {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// challenge asks the client for credentials
+func (c *Credentials) challenge(w http.ResponseWriter, r *http.Request, p *problems.Problem) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="library", charset="UTF-8"`)
+
+	problems.Render(w, r, p)
+}
+
+// equal compares secrets in a time that does not depend on how much of them matches
+func equal(a string, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+
+	return len(a) > 0 && subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// problemCredentialsRequired is returned when a client must authenticate with credentials, but did not send any
+func problemCredentialsRequired() *problems.Problem {
+	return problem.WithEverything(
+		"Credentials Required",
+		"Sign in with the user name and password, or the token, you were given for this library.",
+		[]int{problems.AudienceConsumer, problems.AudienceAPIUser},
+	).WithStatus(http.StatusUnauthorized)
+}
+
+// problemInvalidCredentials is returned when the credentials a client sent are not valid
+func problemInvalidCredentials() *problems.Problem {
+	return problem.WithEverything(
+		"Invalid Credentials",
+		"The user name and password, or the token, are not valid for this library.",
+		[]int{problems.AudienceConsumer, problems.AudienceAPIUser},
+	).WithStatus(http.StatusUnauthorized)
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.pkg.littleman.co/library/internal/nonce"
+	"go.pkg.littleman.co/library/internal/problems"
+)
+
+const (
+	// PolicyNonce is replaced in the Content-Security-Policy by the nonce generated for each request, which the
+	// scripts the library adds to pages are given
+	PolicyNonce = "{nonce}"
+
+	// DefaultContentSecurityPolicy only runs the scripts the library adds to pages, and those of trusted books. Scripts
+	// are not allowed from the library's own origin, as every script in every book is served from it; only those given
+	// the nonce run. Books may still show images, media and fonts from elsewhere, as untrusted books are allowed to.
+	DefaultContentSecurityPolicy = "default-src 'self'; " +
+		"script-src 'nonce-" + PolicyNonce + "'; " +
+		"style-src 'self' 'unsafe-inline'; " +
+		"img-src 'self' data: https:; " +
+		"media-src 'self' data: https:; " +
+		"font-src 'self' data: https:; " +
+		"object-src 'none'; " +
+		"base-uri 'self'; " +
+		"form-action 'self'"
+
+	// DefaultFrameAncestors only allows pages to be framed by the library itself
+	DefaultFrameAncestors = "'self'"
+
+	// DefaultReferrerPolicy tells other sites where readers came from, but not what they were reading
+	DefaultReferrerPolicy = "strict-origin-when-cross-origin"
+
+	// DefaultPermissionsPolicy denies features that reading a book never needs
+	DefaultPermissionsPolicy = "camera=(), microphone=(), geolocation=(), payment=(), usb=(), browsing-topics=()"
+
+	// DefaultHSTSMaxAge is how long browsers are told to only reach the library by HTTPS
+	DefaultHSTSMaxAge = 365 * 24 * time.Hour
+)
+
+// Security sets the headers that limit what browsers allow the pages the library serves to do, so that a book that
+// gets something past the sanitiser cannot do much with it. Any header left empty is not sent.
+type Security struct {
+	// ContentSecurityPolicy is the policy pages are served under. Each PolicyNonce in it is replaced by the nonce of
+	// the request.
+	ContentSecurityPolicy string
+
+	// FrameAncestors are the sources allowed to show pages in frames. They are added to the policy as its
+	// frame-ancestors directive, unless it has one, and to X-Frame-Options for browsers that only understand that.
+	FrameAncestors string
+
+	ReferrerPolicy    string
+	PermissionsPolicy string
+
+	// HSTSMaxAge is how long browsers that reach the library by HTTPS should only reach it that way. Zero sends no
+	// Strict-Transport-Security header.
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+}
+
+// Middleware sets the security headers of every response, and the nonce the scripts in it must have to run
+func (s *Security) Middleware(next http.Handler) http.Handler {
+	policy := s.policy()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+
+		h.Set("X-Content-Type-Options", "nosniff")
+
+		if len(policy) > 0 {
+			if strings.Contains(policy, PolicyNonce) {
+				n, err := nonce.New()
+
+				if err != nil {
+					problems.Render(w, r, err)
+					return
+				}
+
+				r = nonce.WithRequest(r, n)
+				h.Set("Content-Security-Policy", strings.Replace(policy, PolicyNonce, n, -1))
+			} else {
+				h.Set("Content-Security-Policy", policy)
+			}
+		}
+
+		switch s.FrameAncestors {
+		case "'none'":
+			h.Set("X-Frame-Options", "DENY")
+		case "'self'":
+			h.Set("X-Frame-Options", "SAMEORIGIN")
+		}
+
+		if len(s.ReferrerPolicy) > 0 {
+			h.Set("Referrer-Policy", s.ReferrerPolicy)
+		}
+
+		if len(s.PermissionsPolicy) > 0 {
+			h.Set("Permissions-Policy", s.PermissionsPolicy)
+		}
+
+		// Browsers ignore the header over plain HTTP, so the forwarded scheme can be believed without checking where it
+		// came from
+		if s.HSTSMaxAge > 0 && (r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https") {
+			hsts := fmt.Sprintf("max-age=%d", int64(s.HSTSMaxAge/time.Second))
+
+			if s.HSTSIncludeSubdomains {
+				hsts += "; includeSubDomains"
+			}
+
+			h.Set("Strict-Transport-Security", hsts)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// policy returns the Content-Security-Policy, with the frame ancestors added if it does not say who may frame pages
+func (s *Security) policy() string {
+	policy := strings.TrimRight(strings.TrimSpace(s.ContentSecurityPolicy), ";")
+
+	if len(s.FrameAncestors) == 0 {
+		return policy
+	}
+
+	for _, d := range strings.Split(policy, ";") {
+		if f := strings.Fields(d); len(f) > 0 && strings.ToLower(f[0]) == "frame-ancestors" {
+			return policy
+		}
+	}
+
+	if len(policy) == 0 {
+		return "frame-ancestors " + s.FrameAncestors
+	}
+
+	return policy + "; frame-ancestors " + s.FrameAncestors
+}
@@ -1,14 +1,19 @@
 package server
 
 import (
+	"fmt"
+	"log"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"go.pkg.littleman.co/library/internal/book"
 	"go.pkg.littleman.co/library/internal/server/handlers"
 	"go.pkg.littleman.co/library/internal/server/middleware"
+	"go.pkg.littleman.co/library/internal/store"
 )
 
 // OIDCConfig is the authentication configuration for an OIDC Server
@@ -20,10 +25,46 @@ type OIDCConfig struct {
 	ClaimSets    []middleware.OIDCClaimSet
 }
 
+// previewSize is the size of thumbnail wanted when books are shared. The nearest size made that is at least as large is
+// used.
+const previewSize = 512
+
 // Server is the entity that listens to HTTP requests and responds
 type Server struct {
-	address  string
-	bookPath string
+	address     string
+	bookPaths   []string
+	libraryPath string
+
+	// How to reach books kept in S3, or a compatible object store
+	s3 book.S3Config
+
+	// Options applied to every book served
+	bookOptions []func(*book.Book) error
+
+	// Options applied to particular books, by slug
+	slugOptions map[string][]func(*book.Book) error
+
+	// Where readers' progress is kept, if anywhere
+	store *store.Store
+
+	// How often books are checked for changes on disk, and at remote sources. Zero disables reloading.
+	reloadInterval time.Duration
+	fetchInterval  time.Duration
+
+	// Authenticates users with the OIDC provider, if configured
+	oidc *middleware.OidcAuth
+
+	// Authenticates clients that cannot sign in with the OIDC provider, if configured
+	credentials *middleware.Credentials
+
+	// Sets the headers that limit what pages may do, if configured
+	security *middleware.Security
+
+	// Makes and keeps thumbnails of the covers of books
+	thumbnails *book.Thumbnails
+
+	// Where readers reach the library, for pages that must link to it absolutely, if known
+	publicURL string
 
 	middleware []mux.MiddlewareFunc
 }
@@ -34,8 +75,8 @@ type Option func(*Server) error
 // New returns a new server instance
 func New(options ...Option) (*Server, error) {
 	s := &Server{
-		address:  "0.0.0.0:8080",
-		bookPath: "/book.epub",
+		address:     "0.0.0.0:8080",
+		slugOptions: map[string][]func(*book.Book) error{},
 	}
 
 	for _, o := range options {
@@ -47,10 +88,112 @@ func New(options ...Option) (*Server, error) {
 	return s, nil
 }
 
-// WithBook allows supplying the book path to the server
-func WithBook(path string) func(*Server) error {
+// WithBook allows supplying the book path to the server. It may be supplied multiple times to serve multiple books.
+// Books may also be fetched from http(s):// URLs, and from S3 as s3://bucket/key.
+func WithBook(paths ...string) func(*Server) error {
+	return func(s *Server) error {
+		s.bookPaths = append(s.bookPaths, paths...)
+
+		return nil
+	}
+}
+
+// WithLibrary allows supplying a directory, every book of which will be served
+func WithLibrary(dir string) func(*Server) error {
+	return func(s *Server) error {
+		s.libraryPath = dir
+
+		return nil
+	}
+}
+
+// WithInjection adds markup to every document of every book as it is served
+func WithInjection(i *book.Injection) func(*Server) error {
+	return func(s *Server) error {
+		s.bookOptions = append(s.bookOptions, book.WithInjection(i))
+
+		return nil
+	}
+}
+
+// WithoutDownloads withholds the EPUB files of the books addressed by slugs from readers, or of every book if there are
+// no slugs
+func WithoutDownloads(slugs ...string) func(*Server) error {
 	return func(s *Server) error {
-		s.bookPath = path
+		if len(slugs) == 0 {
+			s.bookOptions = append(s.bookOptions, book.WithoutDownload())
+		}
+
+		for _, slug := range slugs {
+			s.slugOptions[slug] = append(s.slugOptions[slug], book.WithoutDownload())
+		}
+
+		return nil
+	}
+}
+
+// WithTrust sets how far the content of the books addressed by slugs is trusted, or of every book if there are no
+// slugs. Books are untrusted unless set otherwise.
+func WithTrust(trust book.Trust, slugs ...string) func(*Server) error {
+	return func(s *Server) error {
+		if len(slugs) == 0 {
+			s.bookOptions = append(s.bookOptions, book.WithTrust(trust))
+		}
+
+		for _, slug := range slugs {
+			s.slugOptions[slug] = append(s.slugOptions[slug], book.WithTrust(trust))
+		}
+
+		return nil
+	}
+}
+
+// WithStore keeps what readers do, such as how far they are through each book, in the store at path
+func WithStore(path string) func(*Server) error {
+	return func(s *Server) error {
+		st, err := store.New(path)
+
+		if err != nil {
+			return errors.Wrap(err, "unable to set up store")
+		}
+
+		s.store = st
+		s.bookOptions = append(s.bookOptions, book.WithAPI(fmt.Sprintf("%s/books", handlers.PrefixAPI)))
+
+		return nil
+	}
+}
+
+// WithReload checks the books on disk for changes every interval, and serves the new version when they change
+func WithReload(interval time.Duration) func(*Server) error {
+	return func(s *Server) error {
+		if interval < 0 {
+			return errors.Errorf("reload interval must not be negative, got %s", interval)
+		}
+
+		s.reloadInterval = interval
+
+		return nil
+	}
+}
+
+// WithFetch checks books at remote sources for changes every interval, rather than every time books on disk are checked
+func WithFetch(interval time.Duration) func(*Server) error {
+	return func(s *Server) error {
+		if interval < 0 {
+			return errors.Errorf("fetch interval must not be negative, got %s", interval)
+		}
+
+		s.fetchInterval = interval
+
+		return nil
+	}
+}
+
+// WithS3 sets how books in S3, or a compatible object store, are fetched
+func WithS3(config book.S3Config) func(*Server) error {
+	return func(s *Server) error {
+		s.s3 = config
 
 		return nil
 	}
@@ -77,12 +220,92 @@ func WithOIDCAuthentication(config *OIDCConfig) func(*Server) error {
 			return errors.Wrap(err, "unable to create OIDC Middleware")
 		}
 
+		s.oidc = auth
 		s.middleware = append(s.middleware, auth.Middleware)
 
 		return nil
 	}
 }
 
+// WithCredentials authenticates clients, such as e-reader apps, by HTTP Basic authentication with users, a map of
+// passwords by user name, or by bearer tokens, a map of the names of their holders by token. If there is also an OIDC
+// provider these are accepted alongside it; otherwise they are required everywhere.
+func WithCredentials(users map[string]string, tokens map[string]string) func(*Server) error {
+	return func(s *Server) error {
+		if len(users) == 0 && len(tokens) == 0 {
+			return errors.New("no users or tokens to authenticate with")
+		}
+
+		s.credentials = &middleware.Credentials{
+			Users:          users,
+			Tokens:         tokens,
+			ChallengePaths: []string{handlers.PrefixOPDS, handlers.PrefixDownloads, handlers.PrefixCovers},
+		}
+
+		return nil
+	}
+}
+
+// WithThumbnails makes thumbnails of the covers of books at sizes, keeping them in dir or, if it is empty, in memory
+func WithThumbnails(sizes []int, dir string) func(*Server) error {
+	return func(s *Server) error {
+		options := []func(*book.Thumbnails) error{book.WithThumbnailSizes(sizes...)}
+
+		if len(dir) > 0 {
+			options = append(options, book.WithThumbnailDirectory(dir))
+		}
+
+		t, err := book.NewThumbnails(options...)
+
+		if err != nil {
+			return errors.Wrap(err, "unable to set up thumbnails")
+		}
+
+		s.thumbnails = t
+
+		return nil
+	}
+}
+
+// WithImageWidths offers browsers variants of the images in books scaled down to widths, so that small screens need not
+// download images larger than they can show. With none, no variants are offered.
+func WithImageWidths(widths ...int) func(*Server) error {
+	return func(s *Server) error {
+		s.bookOptions = append(s.bookOptions, book.WithImageWidths(widths...))
+
+		return nil
+	}
+}
+
+// WithPublicURL declares where readers reach the library, such as https://books.example.com, so that pages shared
+// elsewhere can link back to it
+func WithPublicURL(u string) func(*Server) error {
+	return func(s *Server) error {
+		parsed, err := url.Parse(u)
+
+		if err != nil || len(parsed.Scheme) == 0 || len(parsed.Host) == 0 {
+			return errors.Errorf("invalid public url %q, must be absolute", u)
+		}
+
+		s.publicURL = strings.TrimSuffix(parsed.String(), "/")
+
+		return nil
+	}
+}
+
+// WithSecurity sets the security headers of every response, including those rejected before they reach the library
+func WithSecurity(config middleware.Security) func(*Server) error {
+	return func(s *Server) error {
+		if len(config.ContentSecurityPolicy) > 0 && !strings.Contains(config.ContentSecurityPolicy, middleware.PolicyNonce) {
+			log.Printf("the content security policy has no %s, so the library's own scripts will only run if it allows them otherwise", middleware.PolicyNonce)
+		}
+
+		s.security = &config
+
+		return nil
+	}
+}
+
 // WithLogging enables the logging on the server component
 func WithLogging() func(*Server) error {
 	return func(s *Server) error {
@@ -100,10 +323,57 @@ func WithLogging() func(*Server) error {
 
 // Serve starts the server
 func (s Server) Serve() error {
-	Book, err := book.New(book.WithEPUB(s.bookPath))
+	if s.thumbnails == nil {
+		t, err := book.NewThumbnails()
+
+		if err != nil {
+			return errors.Wrap(err, "unable to set up thumbnails")
+		}
+
+		s.thumbnails = t
+	}
+
+	// Books are shown with their cover when they are shared
+	s.bookOptions = append(s.bookOptions, book.WithPreview(s.publicURL+handlers.PrefixCovers, s.thumbnails.Fit(previewSize)))
+
+	options := []func(*book.Library) error{
+		book.WithBookOptions(s.bookOptions...),
+	}
+
+	for slug, o := range s.slugOptions {
+		options = append(options, book.WithBookOptionsFor(slug, o...))
+	}
+
+	sources := []book.Source{}
+
+	for _, p := range s.bookPaths {
+		src, err := book.ParseSource(p, s.s3)
+
+		if err != nil {
+			return errors.Wrap(err, "unable to create library")
+		}
+
+		sources = append(sources, src)
+	}
+
+	options = append(options, book.WithSources(sources...))
+
+	if s.fetchInterval > 0 {
+		options = append(options, book.WithFetchInterval(s.fetchInterval))
+	}
+
+	if len(s.libraryPath) > 0 {
+		options = append(options, book.WithDirectory(s.libraryPath))
+	}
+
+	library, err := book.NewLibrary(options...)
 
 	if err != nil {
-		return errors.Wrap(err, "unable to create http book")
+		return errors.Wrap(err, "unable to create library")
+	}
+
+	if s.reloadInterval > 0 {
+		go library.Watch(s.reloadInterval, nil)
 	}
 
 	// Specialized routes
@@ -114,10 +384,57 @@ func (s Server) Serve() error {
 
 	// Bind the routes)
 	r.Use(s.middleware...)
-	r.PathPrefix("/").HandlerFunc(Book.Handler)
 
-	// Set router to HTTP server
-	http.Handle("/", r)
+	if s.credentials != nil {
+		if s.oidc != nil {
+			s.oidc.Credentials = s.credentials
+		} else {
+			r.Use(s.credentials.Middleware)
+		}
+	}
+
+	// Requests that match no route are answered before any middleware runs, so the OIDC callback, which its
+	// middleware answers, needs a route of its own
+	if s.oidc != nil && len(s.oidc.RedirectURL.Path) > 0 {
+		r.Path(s.oidc.RedirectURL.Path).HandlerFunc(handlers.NoContent)
+	}
+
+	r.Path("/").HandlerFunc(handlers.Index(library, s.store, s.thumbnails))
+
+	api := r.PathPrefix(handlers.PrefixAPI).Subrouter()
+	api.Path("/books").Methods(http.MethodGet).HandlerFunc(handlers.APIBooks(library))
+	api.Path("/books/{slug}").Methods(http.MethodGet).HandlerFunc(handlers.APIBook(library))
+	api.Path("/books/{slug}/toc").Methods(http.MethodGet).HandlerFunc(handlers.APIContents(library))
+
+	if s.store != nil {
+		api.Path("/progress").Methods(http.MethodGet).HandlerFunc(handlers.AllProgress(s.store))
+		api.Path("/books/{slug}/progress").Methods(http.MethodGet, http.MethodPut).HandlerFunc(handlers.Progress(library, s.store))
+		api.Path("/books/{slug}/annotations").Methods(http.MethodGet, http.MethodPost).HandlerFunc(handlers.Annotations(library, s.store))
+		api.Path("/books/{slug}/annotations/{id}").Methods(http.MethodDelete).HandlerFunc(handlers.Annotation(library, s.store))
+		api.Path("/books/{slug}/threads").Methods(http.MethodGet, http.MethodPost).HandlerFunc(handlers.Threads(library, s.store))
+		api.Path("/books/{slug}/threads/export").Methods(http.MethodGet).HandlerFunc(handlers.ThreadsExport(library, s.store))
+		api.Path("/books/{slug}/threads/{id}").Methods(http.MethodPatch).HandlerFunc(handlers.Thread(library, s.store))
+		api.Path("/books/{slug}/threads/{id}/comments").Methods(http.MethodPost).HandlerFunc(handlers.ThreadComments(library, s.store))
+	}
+
+	r.Path(handlers.PrefixOPDS + handlers.PathOPDSAtom).Methods(http.MethodGet).HandlerFunc(handlers.OPDSAtom(library, s.thumbnails))
+	r.Path(handlers.PrefixOPDS + handlers.PathOPDSJSON).Methods(http.MethodGet).HandlerFunc(handlers.OPDSJSON(library, s.thumbnails))
+	r.Path(fmt.Sprintf("%s/{slug}", handlers.PrefixCovers)).Methods(http.MethodGet, http.MethodHead).HandlerFunc(handlers.Cover(library))
+	r.Path(fmt.Sprintf("%s/{slug}/{size:[0-9]+}", handlers.PrefixCovers)).Methods(http.MethodGet, http.MethodHead).HandlerFunc(handlers.Thumbnail(library, s.thumbnails))
+	r.Path(fmt.Sprintf("%s/{slug}.epub", handlers.PrefixDownloads)).Methods(http.MethodGet).HandlerFunc(handlers.Download(library))
+
+	r.Path(fmt.Sprintf("%s/{slug}", handlers.PrefixBooks)).HandlerFunc(handlers.Book(library))
+	r.PathPrefix(fmt.Sprintf("%s/{slug}/", handlers.PrefixBooks)).HandlerFunc(handlers.Book(library))
+
+	// Set router to HTTP server. The security headers are set around the whole router, rather than as its middleware,
+	// so that they are also sent with the responses to requests no route matches.
+	var handler http.Handler = r
+
+	if s.security != nil {
+		handler = s.security.Middleware(r)
+	}
+
+	http.Handle("/", handler)
 
 	return http.ListenAndServe(s.address, nil)
 }
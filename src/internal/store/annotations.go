@@ -0,0 +1,137 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var bucketAnnotations = []byte("annotations")
+
+const (
+	// MotivationBookmarking marks a place in a book to return to
+	MotivationBookmarking = "bookmarking"
+
+	// MotivationHighlighting marks a passage of a book as interesting
+	MotivationHighlighting = "highlighting"
+
+	// MotivationCommenting attaches a note to a passage of a book
+	MotivationCommenting = "commenting"
+)
+
+const (
+	// SelectorTextQuote selects text by quoting it, along with some of the text either side to tell it apart from
+	// other places the same text appears
+	SelectorTextQuote = "TextQuoteSelector"
+
+	// SelectorTextPosition selects text by its position within the text of the document
+	SelectorTextPosition = "TextPositionSelector"
+
+	// SelectorFragment selects an element of the document by its ID
+	SelectorFragment = "FragmentSelector"
+)
+
+// Annotation is something a reader has marked in a book, modelled on the W3C Web Annotation Data Model.
+//
+// See https://www.w3.org/TR/annotation-model/
+type Annotation struct {
+	// ID identifies the annotation within the book
+	ID string `json:"id"`
+
+	// Book is the slug of the book the annotation is in
+	Book string `json:"book"`
+
+	// Motivation is why the annotation was made; one of the Motivation constants
+	Motivation string `json:"motivation"`
+
+	// BodyValue is the note attached to the annotation, if there is one
+	BodyValue string `json:"bodyValue,omitempty"`
+
+	// Target is what was annotated
+	Target Target `json:"target"`
+
+	// Created is when the annotation was made
+	Created time.Time `json:"created"`
+}
+
+// Target is the part of a book an annotation refers to
+type Target struct {
+	// Source is the document the annotation is in, relative to the content root of the book
+	Source string `json:"source"`
+
+	// Selector are the ways the annotated text can be found within the source. A client should prefer the quote, so
+	// that annotations survive the book being edited, and fall back to the position.
+	Selector []Selector `json:"selector,omitempty"`
+}
+
+// Selector describes how to find the text of an annotation within its source
+type Selector struct {
+	// Type is the kind of selector; one of the Selector constants
+	Type string `json:"type"`
+
+	// Exact is the quoted text, for a TextQuoteSelector
+	Exact string `json:"exact,omitempty"`
+
+	// Prefix is the text immediately before the quote, for a TextQuoteSelector
+	Prefix string `json:"prefix,omitempty"`
+
+	// Suffix is the text immediately after the quote, for a TextQuoteSelector
+	Suffix string `json:"suffix,omitempty"`
+
+	// Start is the offset of the first character of the text, for a TextPositionSelector
+	Start *int `json:"start,omitempty"`
+
+	// End is the offset after the last character of the text, for a TextPositionSelector
+	End *int `json:"end,omitempty"`
+
+	// Value is the ID of the element, for a FragmentSelector
+	Value string `json:"value,omitempty"`
+}
+
+// Annotations returns everything the reader identified by subject has marked in the book, in the order it was marked
+func (s *Store) Annotations(subject string, book string) ([]*Annotation, error) {
+	annotations := []*Annotation{}
+
+	err := s.list(bucketAnnotations, key(subject, book, ""), func(value []byte) error {
+		a := &Annotation{}
+
+		if err := json.Unmarshal(value, a); err != nil {
+			return err
+		}
+
+		annotations = append(annotations, a)
+
+		return nil
+	})
+
+	return annotations, errors.Wrap(err, "unable to read annotations")
+}
+
+// AddAnnotation records something the reader identified by subject has marked, assigning it an ID
+func (s *Store) AddAnnotation(subject string, a *Annotation) error {
+	id, err := newID()
+
+	if err != nil {
+		return errors.Wrap(err, "unable to create annotation id")
+	}
+
+	a.ID = id
+	a.Created = time.Now().UTC()
+
+	return errors.Wrap(s.put(bucketAnnotations, key(subject, a.Book, a.ID), a), "unable to write annotation")
+}
+
+// DeleteAnnotation removes something the reader identified by subject has marked, returning false if there was
+// nothing to remove
+func (s *Store) DeleteAnnotation(subject string, book string, id string) (bool, error) {
+	k := key(subject, book, id)
+
+	found, err := s.get(bucketAnnotations, k, &Annotation{})
+
+	if err != nil || !found {
+		return false, errors.Wrap(err, "unable to delete annotation")
+	}
+
+	return true, errors.Wrap(s.delete(bucketAnnotations, k), "unable to delete annotation")
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var bucketProgress = []byte("progress")
+
+// Progress is how far through a book a reader is
+type Progress struct {
+	// Book is the slug of the book being read
+	Book string `json:"book"`
+
+	// Path is the document last being read, relative to the content root of the book
+	Path string `json:"path"`
+
+	// Position is how far through the document the reader had scrolled, from 0 (the top) to 1 (the bottom)
+	Position float64 `json:"position"`
+
+	// Updated is when the progress was recorded
+	Updated time.Time `json:"updated"`
+}
+
+// Progress returns how far the reader identified by subject is through the book, if they have started it
+func (s *Store) Progress(subject string, book string) (*Progress, error) {
+	p := &Progress{}
+
+	found, err := s.get(bucketProgress, key(subject, book), p)
+
+	if err != nil || !found {
+		return nil, errors.Wrap(err, "unable to read progress")
+	}
+
+	return p, nil
+}
+
+// AllProgress returns how far the reader identified by subject is through every book they have started, keyed by book
+func (s *Store) AllProgress(subject string) (map[string]*Progress, error) {
+	all := map[string]*Progress{}
+
+	err := s.list(bucketProgress, key(subject, ""), func(value []byte) error {
+		p := &Progress{}
+
+		if err := json.Unmarshal(value, p); err != nil {
+			return err
+		}
+
+		all[p.Book] = p
+
+		return nil
+	})
+
+	return all, errors.Wrap(err, "unable to read progress")
+}
+
+// SetProgress records how far the reader identified by subject is through a book, replacing wherever they were before
+func (s *Store) SetProgress(subject string, p *Progress) error {
+	p.Updated = time.Now().UTC()
+
+	return errors.Wrap(s.put(bucketProgress, key(subject, p.Book), p), "unable to write progress")
+}
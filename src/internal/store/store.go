@@ -0,0 +1,169 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// separator divides the parts of a key. It cannot appear in an OIDC subject or a book slug.
+const separator = "\x00"
+
+// Store is the persistent, embedded database that holds everything readers create
+type Store struct {
+	db *bolt.DB
+}
+
+// New opens the store at path, creating it if it does not exist
+func New(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open store at %s", path)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "unable to create store")
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close releases the store, so that it can be opened by something else
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// buckets are created when the store is opened, so they can be assumed to exist
+var buckets = [][]byte{
+	bucketProgress,
+	bucketAnnotations,
+	bucketThreads,
+}
+
+// key joins parts into a key; parts are ordered from least to most specific, so that related records can be listed by
+// prefix.
+func key(parts ...string) []byte {
+	k := bytes.Buffer{}
+
+	for i, p := range parts {
+		if i > 0 {
+			k.WriteString(separator)
+		}
+
+		k.WriteString(p)
+	}
+
+	return k.Bytes()
+}
+
+// put writes v as JSON at key
+func (s *Store) put(bucket []byte, key []byte, v interface{}) error {
+	value, err := json.Marshal(v)
+
+	if err != nil {
+		return errors.Wrap(err, "unable to encode record")
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put(key, value)
+	})
+}
+
+// get reads the JSON at key into v, returning false if there is nothing there
+func (s *Store) get(bucket []byte, key []byte, v interface{}) (bool, error) {
+	found := false
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(bucket).Get(key)
+
+		if value == nil {
+			return nil
+		}
+
+		found = true
+
+		return json.Unmarshal(value, v)
+	})
+
+	if err != nil {
+		return false, errors.Wrap(err, "unable to read record")
+	}
+
+	return found, nil
+}
+
+// update reads the JSON at key into v, calls f to change it and writes it back, all in one transaction. It returns
+// false, without calling f, if there is nothing there.
+func (s *Store) update(bucket []byte, key []byte, v interface{}, f func() error) (bool, error) {
+	found := false
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		value := b.Get(key)
+
+		if value == nil {
+			return nil
+		}
+
+		found = true
+
+		if err := json.Unmarshal(value, v); err != nil {
+			return errors.Wrap(err, "unable to read record")
+		}
+
+		if err := f(); err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(v)
+
+		if err != nil {
+			return errors.Wrap(err, "unable to encode record")
+		}
+
+		return b.Put(key, value)
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	return found, nil
+}
+
+// delete removes whatever is at key
+func (s *Store) delete(bucket []byte, key []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete(key)
+	})
+}
+
+// list calls f with the value of every key that starts with prefix, in key order
+func (s *Store) list(bucket []byte, prefix []byte, f func(value []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if err := f(v); err != nil {
+				return errors.Wrap(err, "unable to read record")
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,158 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var bucketThreads = []byte("threads")
+
+// Thread is a discussion of a paragraph of a book between its reviewers. Unlike annotations, threads are shared with
+// everyone who can read the book.
+type Thread struct {
+	// ID identifies the thread within the book
+	ID string `json:"id"`
+
+	// Book is the slug of the book the thread is in
+	Book string `json:"book"`
+
+	// Target is the paragraph being discussed
+	Target Target `json:"target"`
+
+	// Resolved indicates the discussion is finished, and the thread need not be shown by default
+	Resolved bool `json:"resolved"`
+
+	// Comments are what has been said, in the order it was said. There is always at least one.
+	Comments []Comment `json:"comments"`
+
+	// Created is when the thread was started
+	Created time.Time `json:"created"`
+
+	// Updated is when the thread was last commented on, resolved or reopened
+	Updated time.Time `json:"updated"`
+}
+
+// Comment is something said in a thread
+type Comment struct {
+	// ID identifies the comment within the thread
+	ID string `json:"id"`
+
+	// Author is who said it
+	Author Author `json:"author"`
+
+	// Text is what was said
+	Text string `json:"text"`
+
+	// Created is when it was said
+	Created time.Time `json:"created"`
+}
+
+// Author is a reader, as described by the claims of their sign in
+type Author struct {
+	// Subject uniquely identifies the author with the identity provider
+	Subject string `json:"subject"`
+
+	// Name is what the author is called, if the identity provider says
+	Name string `json:"name,omitempty"`
+
+	// Email is how to reach the author, if the identity provider says
+	Email string `json:"email,omitempty"`
+}
+
+// Threads returns every thread in the book, in the order they were started
+func (s *Store) Threads(book string) ([]*Thread, error) {
+	threads := []*Thread{}
+
+	err := s.list(bucketThreads, key(book, ""), func(value []byte) error {
+		t := &Thread{}
+
+		if err := json.Unmarshal(value, t); err != nil {
+			return err
+		}
+
+		threads = append(threads, t)
+
+		return nil
+	})
+
+	return threads, errors.Wrap(err, "unable to read threads")
+}
+
+// AddThread starts a thread with its first comment, assigning IDs to both
+func (s *Store) AddThread(t *Thread, c Comment) error {
+	id, err := newID()
+
+	if err != nil {
+		return errors.Wrap(err, "unable to create thread")
+	}
+
+	c.ID = id
+	c.Created = time.Now().UTC()
+
+	t.ID = id
+	t.Comments = []Comment{c}
+	t.Created = c.Created
+	t.Updated = c.Created
+
+	return errors.Wrap(s.put(bucketThreads, key(t.Book, t.ID), t), "unable to write thread")
+}
+
+// AddComment replies to a thread, returning the thread as it now is or nil if there is no such thread
+func (s *Store) AddComment(book string, id string, c Comment) (*Thread, error) {
+	t := &Thread{}
+
+	cid, err := newID()
+
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create comment")
+	}
+
+	found, err := s.update(bucketThreads, key(book, id), t, func() error {
+		c.ID = cid
+		c.Created = time.Now().UTC()
+
+		t.Comments = append(t.Comments, c)
+		t.Updated = c.Created
+
+		return nil
+	})
+
+	if err != nil || !found {
+		return nil, errors.Wrap(err, "unable to write comment")
+	}
+
+	return t, nil
+}
+
+// SetResolved resolves or reopens a thread, returning the thread as it now is or nil if there is no such thread
+func (s *Store) SetResolved(book string, id string, resolved bool) (*Thread, error) {
+	t := &Thread{}
+
+	found, err := s.update(bucketThreads, key(book, id), t, func() error {
+		t.Resolved = resolved
+		t.Updated = time.Now().UTC()
+
+		return nil
+	})
+
+	if err != nil || !found {
+		return nil, errors.Wrap(err, "unable to write thread")
+	}
+
+	return t, nil
+}
+
+// newID returns an identifier that starts with the time, so that records are listed in the order they were made
+func newID() (string, error) {
+	suffix := make([]byte, 8)
+
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+
+	return time.Now().UTC().Format("20060102150405") + hex.EncodeToString(suffix), nil
+}